@@ -0,0 +1,180 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// captionFontSize is the font size, in points, rendered at the searched DPI,
+// so the DPI matches the font size in pixels.
+const captionFontSize = 72
+
+func captionImage(image *C.VipsImage, c Caption) (*C.VipsImage, error) {
+	if strings.TrimSpace(c.Text) == "" {
+		return image, nil
+	}
+
+	var err error
+	inWidth := int(image.Xsize)
+	inHeight := int(image.Ysize)
+
+	left, top, width, height := calculateCaptionBox(inWidth, inHeight, c)
+
+	outline := 0
+	if c.Outline.A > 0 && c.OutlineWidth > 0 {
+		outline = c.OutlineWidth
+	}
+
+	// The text is rendered within the padding and the outline
+	textWidth := width - 2*(c.Padding+outline)
+	textHeight := height - 2*(c.Padding+outline)
+	if textWidth <= 0 || textHeight <= 0 {
+		C.g_object_unref(C.gpointer(image))
+		return nil, errors.New("Caption box is smaller than its padding")
+	}
+
+	fontFile := c.FontFile
+	if len(c.FontBuf) > 0 {
+		fontFile, err = writeFontFile(c.FontBuf)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+	}
+
+	font := c.Font
+	if font == "" {
+		font = "sans"
+	}
+	font = font + " " + strconv.Itoa(captionFontSize)
+
+	wrap := textWidth
+	if c.NoWrap {
+		wrap = 0
+	}
+
+	// Search the largest font size which fits the box
+	dpi, err := fitCaptionSize(textHeight, func(dpi int) (bool, error) {
+		mask, err := vipsText(c.Text, font, fontFile, wrap, dpi, c.Align)
+		if err != nil {
+			return false, err
+		}
+		defer C.g_object_unref(C.gpointer(mask))
+		return int(mask.Xsize) <= textWidth && int(mask.Ysize) <= textHeight, nil
+	})
+	if err != nil {
+		C.g_object_unref(C.gpointer(image))
+		return nil, err
+	}
+
+	mask, err := vipsText(c.Text, font, fontFile, wrap, dpi, c.Align)
+	if err != nil {
+		C.g_object_unref(C.gpointer(image))
+		return nil, err
+	}
+
+	if outline > 0 {
+		mask, err = vipsEmbed(mask, outline, outline, int(mask.Xsize)+2*outline, int(mask.Ysize)+2*outline, ExtendBlack, ColorBlack)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+	}
+
+	fill := c.Color
+	if fill.A == 0 {
+		fill = RGBA{0, 0, 0, 255}
+	}
+
+	text, err := vipsTextLayer(mask, outline, fill, c.Outline, 1)
+	if err != nil {
+		C.g_object_unref(C.gpointer(image))
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(text))
+
+	textLeft, textTop := calculateCaptionTextPosition(left, top, width, height, int(text.Xsize), int(text.Ysize), c.Align, c.Padding)
+
+	overlays := []*C.VipsImage{text}
+	layers := []Layer{{Gravity: GravityNorthWest, Left: textLeft, Top: textTop, Opacity: 1}}
+
+	// Draw the background bar below the text
+	if c.Background.A > 0 {
+		bar, err := vipsBoxLayer(width, height, c.Background)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+		defer C.g_object_unref(C.gpointer(bar))
+
+		overlays = append([]*C.VipsImage{bar}, overlays...)
+		layers = append([]Layer{{Gravity: GravityNorthWest, Left: left, Top: top, Opacity: 1}}, layers...)
+	}
+
+	return vipsComposite(image, overlays, layers)
+}
+
+// calculateCaptionBox returns the position and size of the caption box,
+// which spans the image when its width or height is not defined.
+func calculateCaptionBox(inWidth, inHeight int, c Caption) (int, int, int, int) {
+	width, height := c.Width, c.Height
+	if width <= 0 || width > inWidth {
+		width = inWidth
+	}
+	if height <= 0 || height > inHeight {
+		height = inHeight
+	}
+
+	left, top := calculateEmbed(width, height, inWidth, inHeight, c.Gravity)
+	return left, top, width, height
+}
+
+// calculateCaptionTextPosition returns the top left corner of the text, aligned
+// horizontally within the padding of the box and centred vertically.
+func calculateCaptionTextPosition(left, top, width, height, textWidth, textHeight int, align Align, padding int) (int, int) {
+	textTop := top + (height-textHeight)/2
+
+	switch align {
+	case AlignCentre:
+		return left + (width-textWidth)/2, textTop
+	case AlignRight:
+		return left + width - padding - textWidth, textTop
+	}
+
+	return left + padding, textTop
+}
+
+// fitCaptionSize returns the largest DPI, up to the given height, for which
+// the rendered text fits, or an error if the text does not fit at all.
+func fitCaptionSize(height int, fits func(dpi int) (bool, error)) (int, error) {
+	lo, hi := 1, height
+	ok, err := fits(lo)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errors.New("Caption text does not fit the box")
+	}
+
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		ok, err := fits(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return lo, nil
+}
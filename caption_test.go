@@ -0,0 +1,138 @@
+package bimg
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+	"testing"
+)
+
+func TestCaption(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	newImg, err := Resize(buf, Options{
+		Width:  800,
+		Height: 600,
+		Crop:   true,
+		Caption: Caption{
+			Text:       "<b>bimg</b> captions fit the box",
+			Color:      RGBA{255, 0, 0, 255},
+			Background: RGBA{0, 0, 255, 255},
+			Gravity:    GravitySouth,
+			Height:     150,
+			Padding:    10,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Cannot caption the image: %s", err)
+	}
+
+	if err := assertSize(newImg, 800, 600); err != nil {
+		t.Error(err)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(newImg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The bar covers the bottom of the image, and the text is painted over it
+	blue, red := 0, 0
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 800; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			switch {
+			case r>>8 < 50 && g>>8 < 50 && b>>8 > 200:
+				if y < 450 {
+					t.Fatalf("Expected the bar at the bottom, found at %d,%d", x, y)
+				}
+				blue++
+			case r>>8 > 200 && g>>8 < 50 && b>>8 < 50:
+				if y < 450 {
+					t.Fatalf("Expected the text at the bottom, found at %d,%d", x, y)
+				}
+				red++
+			}
+		}
+	}
+	if blue == 0 || red == 0 {
+		t.Errorf("Expected the bar and the text, got %d and %d pixels", blue, red)
+	}
+}
+
+func TestCaptionTooSmall(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	_, err := Resize(buf, Options{
+		Caption: Caption{Text: "bimg", Height: 10, Padding: 5},
+	})
+	if err == nil {
+		t.Error("Expected an error for a box smaller than its padding")
+	}
+}
+
+func TestCalculateCaptionBox(t *testing.T) {
+	cases := []struct {
+		caption                  Caption
+		left, top, width, height int
+	}{
+		{Caption{}, 0, 0, 800, 600},
+		{Caption{Height: 100, Gravity: GravitySouth}, 0, 500, 800, 100},
+		{Caption{Height: 100, Gravity: GravityNorth}, 0, 0, 800, 100},
+		{Caption{Width: 200, Height: 100, Gravity: GravityCentre}, 300, 250, 200, 100},
+		{Caption{Width: 200, Height: 100, Gravity: GravitySouthEast}, 600, 500, 200, 100},
+		{Caption{Width: 1000, Height: 1000}, 0, 0, 800, 600},
+	}
+
+	for _, tc := range cases {
+		left, top, width, height := calculateCaptionBox(800, 600, tc.caption)
+		if left != tc.left || top != tc.top || width != tc.width || height != tc.height {
+			t.Errorf("Invalid box for %+v: got %d,%d %dx%d, expected %d,%d %dx%d",
+				tc.caption, left, top, width, height, tc.left, tc.top, tc.width, tc.height)
+		}
+	}
+}
+
+func TestCalculateCaptionTextPosition(t *testing.T) {
+	cases := []struct {
+		align     Align
+		left, top int
+	}{
+		{AlignLeft, 110, 540},
+		{AlignCentre, 350, 540},
+		{AlignRight, 590, 540},
+	}
+
+	for _, tc := range cases {
+		left, top := calculateCaptionTextPosition(100, 500, 600, 100, 100, 20, tc.align, 10)
+		if left != tc.left || top != tc.top {
+			t.Errorf("Invalid position for align %d: got %d,%d, expected %d,%d", tc.align, left, top, tc.left, tc.top)
+		}
+	}
+}
+
+func TestFitCaptionSize(t *testing.T) {
+	calls := 0
+	dpi, err := fitCaptionSize(200, func(dpi int) (bool, error) {
+		calls++
+		return dpi <= 37, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dpi != 37 {
+		t.Errorf("Invalid size: got %d, expected 37", dpi)
+	}
+	if calls > 10 {
+		t.Errorf("Expected a binary search, got %d calls", calls)
+	}
+
+	if _, err := fitCaptionSize(200, func(int) (bool, error) { return false, nil }); err == nil {
+		t.Error("Expected an error when the text does not fit")
+	}
+
+	failure := errors.New("failure")
+	if _, err := fitCaptionSize(200, func(int) (bool, error) { return false, failure }); err != failure {
+		t.Errorf("Expected the render error, got %v", err)
+	}
+}
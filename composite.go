@@ -0,0 +1,64 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+)
+
+// Composite stacks the given layers over the image, from the bottom to the top,
+// and returns the result in the format of the image.
+func Composite(buf []byte, layers []Layer) ([]byte, error) {
+	if len(layers) == 0 {
+		return nil, errors.New("Composite layers are required")
+	}
+	return Resize(buf, Options{Layers: layers})
+}
+
+func compositeImage(image *C.VipsImage, layers []Layer) (*C.VipsImage, error) {
+	if len(layers) == 0 {
+		return image, nil
+	}
+
+	// Copy the layers, which are updated with the default values
+	layers = append([]Layer(nil), layers...)
+
+	overlays := make([]*C.VipsImage, 0, len(layers))
+	defer func() {
+		for _, overlay := range overlays {
+			C.g_object_unref(C.gpointer(overlay))
+		}
+	}()
+
+	for i := range layers {
+		if len(layers[i].Buf) == 0 {
+			C.g_object_unref(C.gpointer(image))
+			return nil, errors.New("Composite layer image buffer is empty")
+		}
+
+		overlay, _, err := vipsRead(layers[i].Buf)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+		overlays = append(overlays, overlay)
+
+		// Same defaults as image watermarks
+		if layers[i].Opacity == 0 {
+			layers[i].Opacity = 1
+		}
+	}
+
+	return vipsComposite(image, overlays, layers)
+}
+
+// calculateLayerPosition returns the top left corner of a layer, placed on
+// the image according to its gravity and moved by its offsets.
+func calculateLayerPosition(inWidth, inHeight, layerWidth, layerHeight int, l Layer) (int, int) {
+	left, top := calculateEmbed(layerWidth, layerHeight, inWidth, inHeight, l.Gravity)
+	return left + l.Left, top + l.Top
+}
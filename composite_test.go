@@ -0,0 +1,136 @@
+package bimg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestComposite(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+	red := solidImage(t, 100, 100, color.NRGBA{R: 255, A: 255})
+
+	newImg, err := Composite(buf, []Layer{
+		{Buf: red, Gravity: GravityNorthWest, Left: 10, Top: 20},
+		{Buf: red, Gravity: GravitySouthEast, Opacity: 0.5},
+	})
+	if err != nil {
+		t.Fatalf("Cannot composite the image: %s", err)
+	}
+
+	if DetermineImageType(newImg) != JPEG {
+		t.Fatal("Image is not jpeg")
+	}
+	if err := assertSize(newImg, 1680, 1050); err != nil {
+		t.Error(err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(newImg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r, g, b, _ := img.At(60, 70).RGBA(); r>>8 < 240 || g>>8 > 15 || b>>8 > 15 {
+		t.Errorf("Invalid layer colour: %d, %d, %d", r>>8, g>>8, b>>8)
+	}
+	if r, g, b, _ := img.At(5, 5).RGBA(); r>>8 > 240 && g>>8 < 15 && b>>8 < 15 {
+		t.Error("The layer is out of its position")
+	}
+	if r, _, _, _ := img.At(1630, 1000).RGBA(); r>>8 < 127 {
+		t.Errorf("Invalid layer opacity, red: %d", r>>8)
+	}
+
+	if _, err := Composite(buf, nil); err == nil {
+		t.Error("Expected an error without layers")
+	}
+}
+
+func TestCompositeBlendModes(t *testing.T) {
+	gray := solidImage(t, 10, 10, color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+	white := solidImage(t, 10, 10, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	black := solidImage(t, 10, 10, color.NRGBA{A: 255})
+
+	tests := []struct {
+		layer    []byte
+		blend    BlendMode
+		expected uint32
+	}{
+		{white, BlendOver, 255},
+		{white, BlendMultiply, 128},
+		{black, BlendMultiply, 0},
+		{white, BlendScreen, 255},
+		{black, BlendScreen, 128},
+		{white, BlendDarken, 128},
+		{black, BlendLighten, 128},
+		{white, BlendDifference, 127},
+	}
+
+	for _, test := range tests {
+		newImg, err := Composite(gray, []Layer{{Buf: test.layer, Blend: test.blend}})
+		if err != nil {
+			t.Fatalf("Cannot composite with blend mode %d: %s", test.blend, err)
+		}
+
+		img, err := png.Decode(bytes.NewReader(newImg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, _, _, a := img.At(5, 5).RGBA()
+		if diff := int(r>>8) - int(test.expected); diff < -2 || diff > 2 || a>>8 != 255 {
+			t.Errorf("Invalid colour with blend mode %d: %d (alpha %d), expected %d", test.blend, r>>8, a>>8, test.expected)
+		}
+	}
+}
+
+func TestCalculateLayerPosition(t *testing.T) {
+	tests := []struct {
+		layer     Layer
+		left, top int
+	}{
+		{Layer{}, 450, 350},
+		{Layer{Left: 10, Top: -20}, 460, 330},
+		{Layer{Gravity: GravityNorthWest, Left: 10, Top: 20}, 10, 20},
+		{Layer{Gravity: GravitySouthEast}, 900, 700},
+		{Layer{Gravity: GravityNorth, Top: 5}, 450, 5},
+		{Layer{Gravity: GravityWest, Left: -50}, -50, 350},
+	}
+
+	for _, test := range tests {
+		left, top := calculateLayerPosition(1000, 800, 100, 100, test.layer)
+		if left != test.left || top != test.top {
+			t.Errorf("Invalid position for %#v: %d, %d, expected %d, %d", test.layer, left, top, test.left, test.top)
+		}
+	}
+}
+
+func solidImage(t *testing.T, width, height int, c color.NRGBA) []byte {
+	return drawImage(t, width, height, func(x, y int) color.NRGBA { return c })
+}
+
+// drawImage encodes a PNG image whose pixels are given by the pixel function.
+func drawImage(t *testing.T, width, height int, pixel func(x, y int) color.NRGBA) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, pixel(x, y))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decodePixel decodes the image and returns the color of the given pixel.
+func decodePixel(t *testing.T, buf []byte, x, y int) color.NRGBA {
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
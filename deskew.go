@@ -0,0 +1,175 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"math"
+)
+
+const (
+	// deskewMaxAngle defines the maximum skew angle, in degrees, detected by Deskew.
+	deskewMaxAngle = 15.0
+	// deskewAnalysisSize defines the maximum width or height of the analysis copy.
+	deskewAnalysisSize = 800
+)
+
+// SkewAngle returns the skew angle of a scanned document, in clockwise degrees,
+// as detected by the Deskew option. The angle is measured after EXIF auto-rotation
+// and ranges between -15 and 15 degrees.
+func SkewAngle(buf []byte) (float64, error) {
+	defer C.vips_thread_shutdown()
+
+	image, _, err := loadImage(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	image, _, err = rotateAndFlipImage(image, &Options{})
+	if err != nil {
+		return 0, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	return calculateSkew(image)
+}
+
+// calculateSkew estimates the skew angle of the given image using the
+// projection profile of a downsampled and thresholded copy.
+// The given image is not consumed.
+func calculateSkew(image *C.VipsImage) (float64, error) {
+	var err error
+	inWidth := int(image.Xsize)
+	inHeight := int(image.Ysize)
+
+	// The analysis copy is built on a new reference, leaving the input untouched
+	C.g_object_ref(C.gpointer(image))
+	analysis := image
+
+	if inWidth > deskewAnalysisSize || inHeight > deskewAnalysisSize {
+		o := Options{Width: deskewAnalysisSize}
+		if inHeight > inWidth {
+			o = Options{Height: deskewAnalysisSize}
+		}
+
+		analysis, err = scaleImage(analysis, o)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	width := int(analysis.Xsize)
+	height := int(analysis.Ysize)
+
+	pixels, err := vipsGreyPixels(analysis)
+	if err != nil {
+		return 0, err
+	}
+
+	return calculateSkewAngle(pixels, width, height, deskewMaxAngle), nil
+}
+
+// calculateSkewAngle finds the rotation that maximizes the variance of the
+// horizontal projection profile of the dark pixels, which happens when text
+// lines or edges are horizontal. The skew is the opposite of that rotation.
+func calculateSkewAngle(pixels []byte, width, height int, maxAngle float64) float64 {
+	threshold := otsuThreshold(pixels)
+
+	var xs, ys []float64
+	cx, cy := float64(width)/2, float64(height)/2
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if pixels[y*width+x] < threshold {
+				xs = append(xs, float64(x)-cx)
+				ys = append(ys, float64(y)-cy)
+			}
+		}
+	}
+
+	if len(xs) == 0 {
+		return 0
+	}
+
+	diagonal := int(math.Ceil(math.Hypot(float64(width), float64(height))))
+	bins := make([]float64, diagonal+1)
+
+	score := func(angle float64) float64 {
+		for i := range bins {
+			bins[i] = 0
+		}
+
+		radians := angle * math.Pi / 180
+		sin, cos := math.Sin(radians), math.Cos(radians)
+		for i := range xs {
+			bin := int(xs[i]*sin+ys[i]*cos) + diagonal/2
+			if bin >= 0 && bin < len(bins) {
+				bins[bin]++
+			}
+		}
+
+		sum := 0.0
+		for _, count := range bins {
+			sum += count * count
+		}
+		return sum
+	}
+
+	search := func(from, to, step float64) float64 {
+		best, bestScore := 0.0, -1.0
+		for angle := from; angle <= to+step/2; angle += step {
+			if s := score(angle); s > bestScore {
+				best, bestScore = angle, s
+			}
+		}
+		return best
+	}
+
+	// Coarse search over the full range, then refine around the best match
+	correction := search(-maxAngle, maxAngle, 0.5)
+	correction = search(correction-0.5, correction+0.5, 0.05)
+
+	return -math.Floor(correction*100+0.5) / 100
+}
+
+// otsuThreshold returns the grey level that best separates the
+// dark and light pixels according to Otsu's method.
+func otsuThreshold(pixels []byte) byte {
+	var histogram [256]float64
+	for _, p := range pixels {
+		histogram[p]++
+	}
+
+	total := float64(len(pixels))
+	sum := 0.0
+	for i, count := range histogram {
+		sum += float64(i) * count
+	}
+
+	var threshold byte
+	sumDark, weightDark, maxVariance := 0.0, 0.0, 0.0
+	for i, count := range histogram {
+		weightDark += count
+		if weightDark == 0 {
+			continue
+		}
+		weightLight := total - weightDark
+		if weightLight == 0 {
+			break
+		}
+
+		sumDark += float64(i) * count
+		meanDark := sumDark / weightDark
+		meanLight := (sum - sumDark) / weightLight
+
+		variance := weightDark * weightLight * (meanDark - meanLight) * (meanDark - meanLight)
+		if variance > maxVariance {
+			maxVariance = variance
+			threshold = byte(i + 1)
+		}
+	}
+
+	return threshold
+}
@@ -0,0 +1,94 @@
+package bimg
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestSkewAngle(t *testing.T) {
+	white := Color{255, 255, 255}
+
+	for _, skew := range []float64{4, -6.5} {
+		// Rotating a straight page clockwise skews its lines down to the right
+		skewed, err := Resize(textPage(t), Options{Rotation: Rotation{Angle: skew}, Background: white, Type: PNG})
+		if err != nil {
+			t.Fatalf("Cannot rotate the page: %s", err)
+		}
+
+		angle, err := SkewAngle(skewed)
+		if err != nil {
+			t.Fatalf("Cannot detect the skew angle: %s", err)
+		}
+		if math.Abs(angle-skew) > 0.5 {
+			t.Errorf("Invalid skew angle: %f, expected %f", angle, skew)
+		}
+	}
+}
+
+func TestDeskew(t *testing.T) {
+	white := Color{255, 255, 255}
+
+	for _, skew := range []float64{4, -6.5} {
+		skewed, err := Resize(textPage(t), Options{Rotation: Rotation{Angle: skew}, Background: white, Type: PNG})
+		if err != nil {
+			t.Fatalf("Cannot rotate the page: %s", err)
+		}
+
+		options := Options{Deskew: true, Background: white, Type: PNG}
+		newImg, err := Resize(skewed, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		angle, err := SkewAngle(newImg)
+		if err != nil {
+			t.Fatalf("Cannot detect the skew angle: %s", err)
+		}
+		if math.Abs(angle) > 0.5 {
+			t.Errorf("Expected a straight page after deskewing %f, got %f", skew, angle)
+		}
+	}
+}
+
+func TestCalculateSkewAngle(t *testing.T) {
+	width, height := 600, 400
+
+	for _, skew := range []float64{0, 3, -7.5, 12} {
+		pixels := make([]byte, width*height)
+		for i := range pixels {
+			pixels[i] = 240
+		}
+
+		// Draw dashed text-like lines going down to the right for positive angles
+		slope := math.Tan(skew * math.Pi / 180)
+		for line := 0; line < 15; line++ {
+			for x := 0; x < width; x++ {
+				if x%7 == 0 {
+					continue
+				}
+				y := int(float64(line*30-50) + float64(x)*slope)
+				for d := 0; d < 3; d++ {
+					if y+d >= 0 && y+d < height {
+						pixels[(y+d)*width+x] = 20
+					}
+				}
+			}
+		}
+
+		angle := calculateSkewAngle(pixels, width, height, deskewMaxAngle)
+		if math.Abs(angle-skew) > 0.1 {
+			t.Errorf("Invalid skew angle: %f, expected %f", angle, skew)
+		}
+	}
+}
+
+// textPage returns a white page with dashed, text-like horizontal lines.
+func textPage(t *testing.T) []byte {
+	return drawImage(t, 600, 400, func(x, y int) color.NRGBA {
+		if x >= 50 && x < 550 && y >= 40 && y < 360 && y%30 < 3 && x%7 != 0 {
+			return color.NRGBA{20, 20, 20, 255}
+		}
+		return color.NRGBA{255, 255, 255, 255}
+	})
+}
@@ -52,6 +52,12 @@ func (i *Image) SmartCrop(width, height int) ([]byte, error) {
 	return i.Process(options)
 }
 
+// SmartCropRect returns the area a smart crop to the given size, with the optional
+// strategy, would keep, without cropping.
+func (i *Image) SmartCropRect(width, height int, strategy ...SmartCropStrategy) (Rect, error) {
+	return SmartCropRect(i.buffer, width, height, strategy...)
+}
+
 // Extract area from the by X/Y axis in the current image.
 func (i *Image) Extract(top, left, width, height int) ([]byte, error) {
 	options := Options{
@@ -141,6 +147,18 @@ func (i *Image) WatermarkImage(w WatermarkImage) ([]byte, error) {
 	return i.Process(options)
 }
 
+// Composite stacks the given layers over the image.
+func (i *Image) Composite(layers ...Layer) ([]byte, error) {
+	options := Options{Layers: layers}
+	return i.Process(options)
+}
+
+// Caption renders the text into a box of the image, fitting its font size.
+func (i *Image) Caption(c Caption) ([]byte, error) {
+	options := Options{Caption: c}
+	return i.Process(options)
+}
+
 // Zoom zooms the image by the given factor.
 // You should probably call Extract() before.
 func (i *Image) Zoom(factor int) ([]byte, error) {
@@ -154,6 +172,30 @@ func (i *Image) Rotate(a Angle) ([]byte, error) {
 	return i.Process(options)
 }
 
+// Deskew rotates a scanned document to correct its detected skew angle,
+// which is the one reported by SkewAngle.
+func (i *Image) Deskew() ([]byte, error) {
+	options := Options{Deskew: true}
+	return i.Process(options)
+}
+
+// SkewAngle returns the detected skew angle of a scanned document in clockwise degrees.
+func (i *Image) SkewAngle() (float64, error) {
+	return SkewAngle(i.buffer)
+}
+
+// Affine transforms the image with the given 2x3 affine matrix.
+func (i *Image) Affine(m Affine) ([]byte, error) {
+	options := Options{Affine: m}
+	return i.Process(options)
+}
+
+// Perspective warps the given source quad of the image onto a rectangle.
+func (i *Image) Perspective(p Perspective) ([]byte, error) {
+	options := Options{Perspective: p}
+	return i.Process(options)
+}
+
 // Flip flips the image about the vertical Y axis.
 func (i *Image) Flip() ([]byte, error) {
 	options := Options{Flip: true}
@@ -203,6 +245,12 @@ func (i *Image) Process(o Options) ([]byte, error) {
 	return image, nil
 }
 
+// ProcessMany renders the image into multiple outputs based on the given
+// transformation options, decoding it only once. The image buffer is not modified.
+func (i *Image) ProcessMany(opts []Options) ([]ResizedImage, error) {
+	return ResizeMany(i.buffer, opts)
+}
+
 // Metadata returns the image metadata (size, alpha channel, profile, EXIF rotation).
 func (i *Image) Metadata() (ImageMetadata, error) {
 	return Metadata(i.buffer)
@@ -1,6 +1,7 @@
 package bimg
 
 import (
+	"bytes"
 	"fmt"
 	"path"
 	"testing"
@@ -300,6 +301,50 @@ func TestImageWatermarkNoReplicate(t *testing.T) {
 	Write("testdata/test_watermark_replicate_out.jpg", buf)
 }
 
+func TestImageComposite(t *testing.T) {
+	image := initImage("test.jpg")
+	layer, _ := imageBuf("transparent.png")
+
+	buf, err := image.Composite(
+		Layer{Buf: layer, Gravity: GravityNorthWest},
+		Layer{Buf: layer, Gravity: GravitySouthEast, Opacity: 0.5, Blend: BlendMultiply},
+	)
+	if err != nil {
+		t.Errorf("Cannot process the image: %#v", err)
+	}
+
+	err = assertSize(buf, 1680, 1050)
+	if err != nil {
+		t.Error(err)
+	}
+
+	Write("testdata/test_composite_out.jpg", buf)
+}
+
+func TestImageCaption(t *testing.T) {
+	image := initImage("test.jpg")
+
+	buf, err := image.Caption(Caption{
+		Text:       "bimg",
+		Color:      RGBA{255, 255, 255, 255},
+		Background: RGBA{0, 0, 0, 160},
+		Align:      AlignCentre,
+		Gravity:    GravitySouth,
+		Height:     200,
+		Padding:    20,
+	})
+	if err != nil {
+		t.Errorf("Cannot process the image: %#v", err)
+	}
+
+	err = assertSize(buf, 1680, 1050)
+	if err != nil {
+		t.Error(err)
+	}
+
+	Write("testdata/test_caption_out.jpg", buf)
+}
+
 func TestImageZoom(t *testing.T) {
 	image := initImage("test.jpg")
 
@@ -475,6 +520,78 @@ func TestImageSmartCrop(t *testing.T) {
 	Write("testdata/test_smart_crop.jpg", buf)
 }
 
+func TestImageSmartCropRect(t *testing.T) {
+	if !(VipsMajorVersion >= 8 && VipsMinorVersion >= 5) {
+		t.Skipf("Skipping this test, libvips doesn't meet version requirement %s >= 8.5", VipsVersion)
+	}
+
+	i := initImage("northern_cardinal_bird.jpg")
+	size, err := i.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The strategy defaults to attention, like Options.SmartCropStrategy
+	rect, err := i.SmartCropRect(300, 300)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	attention, err := i.SmartCropRect(300, 300, SmartCropAttention)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if rect != attention {
+		t.Errorf("Expected the attention crop area by default, got %#v and %#v", rect, attention)
+	}
+
+	for _, strategy := range []SmartCropStrategy{SmartCropAttention, SmartCropEntropy, SmartCropCentre} {
+		rect, err := i.SmartCropRect(300, 300, strategy)
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+
+		if rect.Width != rect.Height {
+			t.Errorf("Invalid aspect ratio: %dx%d", rect.Width, rect.Height)
+		}
+		if rect.Left < 0 || rect.Top < 0 || rect.Left+rect.Width > size.Width || rect.Top+rect.Height > size.Height {
+			t.Errorf("Crop area %#v is out of the image bounds %#v", rect, size)
+		}
+
+		// The centre strategy keeps the middle of the image
+		if strategy == SmartCropCentre {
+			if absInt(size.Width-rect.Width-2*rect.Left) > 1 || absInt(size.Height-rect.Height-2*rect.Top) > 1 {
+				t.Errorf("Expected a centred crop area, got %#v for %#v", rect, size)
+			}
+		}
+	}
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func TestImageSmartCropStrategy(t *testing.T) {
+	if !(VipsMajorVersion >= 8 && VipsMinorVersion >= 5) {
+		t.Skipf("Skipping this test, libvips doesn't meet version requirement %s >= 8.5", VipsVersion)
+	}
+
+	for _, strategy := range []SmartCropStrategy{SmartCropEntropy, SmartCropCentre} {
+		i := initImage("northern_cardinal_bird.jpg")
+		buf, err := i.Process(Options{Width: 300, Height: 300, Crop: true, Gravity: GravitySmart, SmartCropStrategy: strategy})
+		if err != nil {
+			t.Errorf("Cannot process the image: %#v", err)
+		}
+
+		err = assertSize(buf, 300, 300)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
 func TestImageTrim(t *testing.T) {
 
 	if !(VipsMajorVersion >= 8 && VipsMinorVersion >= 6) {
@@ -520,6 +637,29 @@ func TestImageTrimParameters(t *testing.T) {
 	Write("testdata/parameter_trim.png", buf)
 }
 
+func TestImageProcessMany(t *testing.T) {
+	i := initImage("test.jpg")
+	original := i.Image()
+
+	images, err := i.ProcessMany(SrcsetOptions(Options{Type: PNG}, 100, 200))
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	for n, width := range []int{100, 200} {
+		if images[n].Type != PNG || DetermineImageType(images[n].Buffer) != PNG {
+			t.Errorf("Invalid image type: %s", ImageTypeName(images[n].Type))
+		}
+		if err := assertSize(images[n].Buffer, width, images[n].Height); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if !bytes.Equal(i.Image(), original) {
+		t.Error("The image buffer must not be modified")
+	}
+}
+
 func TestImageLength(t *testing.T) {
 	i := initImage("test.jpg")
 
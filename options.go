@@ -29,6 +29,155 @@ const (
 	GravityWest
 	// GravitySmart enables libvips Smart Crop algorithm for image gravity orientation.
 	GravitySmart
+	// GravityNorthEast represents the north-east corner value used for image gravity orientation.
+	GravityNorthEast
+	// GravityNorthWest represents the north-west corner value used for image gravity orientation.
+	GravityNorthWest
+	// GravitySouthEast represents the south-east corner value used for image gravity orientation.
+	GravitySouthEast
+	// GravitySouthWest represents the south-west corner value used for image gravity orientation.
+	GravitySouthWest
+)
+
+// SmartCropStrategy represents the libvips strategy used to find
+// the most interesting area of an image on smart crop.
+type SmartCropStrategy int
+
+const (
+	// SmartCropAttention looks for features likely to draw human attention (libvips default).
+	SmartCropAttention SmartCropStrategy = iota
+	// SmartCropEntropy keeps the area with the highest entropy.
+	SmartCropEntropy
+	// SmartCropCentre keeps the centre of the image.
+	SmartCropCentre
+	// SmartCropLow keeps the lowest-valued pixels (libvips 8.9+).
+	SmartCropLow
+	// SmartCropHigh keeps the highest-valued pixels (libvips 8.9+).
+	SmartCropHigh
+)
+
+// Align represents the alignment of the lines of a text.
+type Align int
+
+const (
+	// AlignLeft aligns the lines on the left (default).
+	AlignLeft Align = iota
+	// AlignCentre centers the lines.
+	AlignCentre
+	// AlignRight aligns the lines on the right.
+	AlignRight
+)
+
+// Normalize represents how the image values are stretched to their full range,
+// between the 1st and the 99th percentiles.
+type Normalize int
+
+const (
+	// NormalizeNone leaves the image unchanged (default).
+	NormalizeNone Normalize = iota
+	// NormalizeLuminance stretches the lightness, which preserves the colours.
+	NormalizeLuminance
+	// NormalizeBands stretches every band separately, which also corrects colour casts.
+	NormalizeBands
+)
+
+// Filter represents a stylistic colour filter, which leaves the alpha band untouched.
+type Filter int
+
+const (
+	// FilterNone leaves the image unchanged (default).
+	FilterNone Filter = iota
+	// FilterGrayscale converts the image to grayscale, keeping its alpha band.
+	FilterGrayscale
+	// FilterSepia gives the image the brown tones of old photographs.
+	FilterSepia
+)
+
+// EdgeDetection represents the operator used to detect the edges of the image,
+// which is converted to grayscale.
+type EdgeDetection int
+
+const (
+	// EdgeNone leaves the image unchanged (default).
+	EdgeNone EdgeDetection = iota
+	// EdgeSobel returns the gradient magnitude with the Sobel operator.
+	EdgeSobel
+	// EdgeScharr returns the gradient magnitude with the Scharr operator, which is more rotation invariant.
+	EdgeScharr
+	// EdgeCanny returns the thin edges found by the Canny detector (libvips 8.8+).
+	EdgeCanny
+)
+
+// RankFilter represents the value picked by a rank filter within its window.
+type RankFilter int
+
+const (
+	// RankNone leaves the image unchanged (default).
+	RankNone RankFilter = iota
+	// RankMedian picks the median value, which removes the noise but keeps the edges.
+	RankMedian
+	// RankMin picks the minimum value, which grows the dark areas.
+	RankMin
+	// RankMax picks the maximum value, which grows the light areas.
+	RankMax
+)
+
+// MorphOperation represents a binary morphological operation.
+type MorphOperation int
+
+const (
+	// MorphNone leaves the image unchanged (default).
+	MorphNone MorphOperation = iota
+	// MorphErode keeps the pixels set only if the whole structuring element is set around them.
+	MorphErode
+	// MorphDilate sets the pixels if any pixel of the structuring element is set around them.
+	MorphDilate
+)
+
+// RedactionMode represents how a redacted area is hidden.
+type RedactionMode int
+
+const (
+	// RedactBlur blurs the area with a gaussian blur (default).
+	RedactBlur RedactionMode = iota
+	// RedactPixelate replaces the area with large blocks of its average colors.
+	RedactPixelate
+	// RedactFill fills the area with a solid color.
+	RedactFill
+)
+
+// BlendMode represents the mode used to blend a layer with the image below it.
+type BlendMode int
+
+const (
+	// BlendOver places the layer over the image (default).
+	BlendOver BlendMode = iota
+	// BlendMultiply multiplies the colours, which darkens the image.
+	BlendMultiply
+	// BlendScreen inverts, multiplies and inverts the colours, which lightens the image.
+	BlendScreen
+	// BlendOverlay multiplies or screens the colours, depending on the image colour.
+	BlendOverlay
+	// BlendDarken keeps the darkest colours.
+	BlendDarken
+	// BlendLighten keeps the lightest colours.
+	BlendLighten
+	// BlendColourDodge brightens the image to reflect the layer.
+	BlendColourDodge
+	// BlendColourBurn darkens the image to reflect the layer.
+	BlendColourBurn
+	// BlendHardLight multiplies or screens the colours, depending on the layer colour.
+	BlendHardLight
+	// BlendSoftLight darkens or lightens the colours, depending on the layer colour.
+	BlendSoftLight
+	// BlendDifference subtracts the darker colours from the lighter ones.
+	BlendDifference
+	// BlendExclusion is similar to BlendDifference, with a lower contrast.
+	BlendExclusion
+	// BlendAdd adds the colours.
+	BlendAdd
+	// BlendSaturate adds the layer as far as the image is transparent.
+	BlendSaturate
 )
 
 // Interpolator represents the image interpolation value.
@@ -56,6 +205,37 @@ func (i Interpolator) String() string {
 	return interpolations[i]
 }
 
+// Kernel represents the resampling kernel used to downscale images.
+type Kernel int
+
+const (
+	// KernelLanczos3 uses a Lanczos kernel with a = 3 (libvips default).
+	KernelLanczos3 Kernel = iota
+	// KernelLanczos2 uses a Lanczos kernel with a = 2.
+	KernelLanczos2
+	// KernelMitchell uses a Mitchell-Netravali kernel (libvips 8.10+).
+	KernelMitchell
+	// KernelCubic uses a Catmull-Rom cubic kernel.
+	KernelCubic
+	// KernelLinear uses a linear kernel.
+	KernelLinear
+	// KernelNearest uses the nearest neighbour, also for enlargements and instead of box shrinking.
+	KernelNearest
+)
+
+var kernels = map[Kernel]string{
+	KernelLanczos3: "lanczos3",
+	KernelLanczos2: "lanczos2",
+	KernelMitchell: "mitchell",
+	KernelCubic:    "cubic",
+	KernelLinear:   "linear",
+	KernelNearest:  "nearest",
+}
+
+func (k Kernel) String() string {
+	return kernels[k]
+}
+
 // Angle represents the image rotation angle value.
 type Angle int
 
@@ -78,6 +258,38 @@ const (
 	D315 Angle = 315
 )
 
+// Rotation represents the arbitrary angle rotation options.
+// Angle is expressed in clockwise degrees. Uncovered corners are filled
+// with the Background color, or left transparent if Transparent is set.
+// Crop removes them by cropping to the largest inscribed rectangle.
+type Rotation struct {
+	Angle        float64
+	Interpolator Interpolator
+	Transparent  bool
+	Crop         bool
+}
+
+// Affine represents a 2x3 affine transformation matrix in row-major order, mapping
+// x' = A[0]*x + A[1]*y + A[2] and y' = A[3]*x + A[4]*y + A[5].
+// The output image covers the bounding box of the transformed image, so the
+// translation of A[2] and A[5] is ignored.
+type Affine [6]float64
+
+// Point represents a point in pixel coordinates.
+type Point struct {
+	X float64
+	Y float64
+}
+
+// Perspective represents a four-point perspective warp, mapping the source quad
+// given by its top-left, top-right, bottom-right and bottom-left corners onto
+// a Width x Height rectangle. Zero Width or Height are inferred from the quad edges.
+type Perspective struct {
+	Points [4]Point
+	Width  int
+	Height int
+}
+
 // Direction represents the image direction value.
 type Direction int
 
@@ -147,27 +359,109 @@ type Color struct {
 	R, G, B uint8
 }
 
+// RGBA represents a RGB color with an alpha value, where 0 is fully transparent.
+type RGBA struct {
+	R, G, B, A uint8
+}
+
 // ColorBlack is a shortcut to black RGB color representation.
 var ColorBlack = Color{0, 0, 0}
 
+// Rect represents a rectangular image area in pixels.
+type Rect struct {
+	Left   int
+	Top    int
+	Width  int
+	Height int
+}
+
+// Redaction represents an area of the image to hide, such as a face or a licence
+// plate. The area is given in pixels of the original image, before any rotation,
+// flip or resize, so coordinates from a detector can be passed as they are.
+// Strength is the blur sigma or the pixelation block size, in the same pixels,
+// and defaults to a tenth of the smallest side of the area. Color fills the area.
+type Redaction struct {
+	Rect     Rect
+	Mode     RedactionMode
+	Strength float64
+	Color    Color
+}
+
 // Watermark represents the text-based watermark supported options.
+// Text may contain Pango markup, so reserved characters must be escaped.
+// The text is painted with the Background color, unless Foreground is set.
+// A font can be loaded from a TTF/OTF file path or bytes, which requires libvips 8.9+.
+// Font bytes are written to a temporary file kept until Shutdown, once per
+// distinct font, so reuse the same bytes rather than building new ones per call.
 type Watermark struct {
-	Width       int
-	DPI         int
-	Margin      int
-	Opacity     float32
-	NoReplicate bool
-	Text        string
-	Font        string
-	Background  Color
+	Width        int
+	DPI          int
+	Margin       int
+	Opacity      float32
+	NoReplicate  bool
+	Text         string
+	Font         string
+	Background   Color
+	Foreground   RGBA
+	Outline      RGBA
+	OutlineWidth int
+	Align        Align
+	UseGravity   bool
+	Gravity      Gravity
+	Angle        float64
+	FontFile     string
+	FontBuf      []byte
 }
 
 // WatermarkImage represents the image-based watermark supported options.
+// The watermark is placed at Left and Top, or by Gravity and Margin when
+// UseGravity is set. Scale defines its width as a percentage of the image
+// width, and Replicate tiles it over the whole image, Margin pixels apart.
 type WatermarkImage struct {
+	Left       int
+	Top        int
+	Buf        []byte
+	Opacity    float32
+	UseGravity bool
+	Gravity    Gravity
+	Margin     int
+	Scale      float64
+	Replicate  bool
+}
+
+// Layer represents an image composited over another one. The layer is placed
+// according to its gravity, and then moved by the Left and Top offsets; use
+// GravityNorthWest to place it at the given coordinates of the image.
+type Layer struct {
+	Buf     []byte
 	Left    int
 	Top     int
-	Buf     []byte
+	Gravity Gravity
 	Opacity float32
+	Blend   BlendMode
+}
+
+// Caption represents a text rendered into a box of the image, with the largest
+// font size that fits the box. The box is placed by Gravity and spans the image
+// width or height when Width or Height are zero. Font defines the font family and
+// style without size, and Color defaults to black. The text wraps at the box width,
+// unless NoWrap is set, and an optional Background bar fills the box.
+// FontBuf is kept in a temporary file like the Watermark one.
+type Caption struct {
+	Text         string
+	Font         string
+	FontFile     string
+	FontBuf      []byte
+	Color        RGBA
+	Background   RGBA
+	Outline      RGBA
+	OutlineWidth int
+	Align        Align
+	Gravity      Gravity
+	Width        int
+	Height       int
+	Padding      int
+	NoWrap       bool
 }
 
 // GaussianBlur represents the gaussian image transformation values.
@@ -176,6 +470,119 @@ type GaussianBlur struct {
 	MinAmpl float64
 }
 
+// FocalPoint represents a point of interest expressed in coordinates relative
+// to the image size, where 0.0 is the top/left edge and 1.0 the bottom/right edge.
+// Crops are centered on it as far as the image bounds allow. Options.FocalPoint
+// is a pointer, so a nil value leaves the crop to the gravity while (0, 0) is
+// a valid top left focal point.
+type FocalPoint struct {
+	X float64
+	Y float64
+}
+
+// Padding represents the space added to each side of the image, filled
+// according to the Extend mode and Background color. Values are pixels, or
+// percentages of the image width (left/right) and height (top/bottom) if Percent is set.
+type Padding struct {
+	Top     int
+	Right   int
+	Bottom  int
+	Left    int
+	Percent bool
+}
+
+// Border represents a solid frame drawn around the image, outside any padding.
+type Border struct {
+	Width int
+	Color Color
+}
+
+// MaskShape represents the shape used to mask the image.
+type MaskShape int
+
+const (
+	// MaskNone disables the mask shape.
+	MaskNone MaskShape = iota
+	// MaskCircle masks the image with the largest centered circle.
+	MaskCircle
+	// MaskEllipse masks the image with an ellipse touching its sides.
+	MaskEllipse
+	// MaskRoundedRect masks the image corners with the Options.CornerRadius value.
+	MaskRoundedRect
+)
+
+// Mask represents the image mask options. The masked out areas become transparent.
+// Buf can provide an arbitrary mask image, whose alpha band (or luminance, if it
+// has no alpha band) is stretched to the image size; it takes precedence over Shape.
+type Mask struct {
+	Shape MaskShape
+	Buf   []byte
+}
+
+// Modulate represents the brightness, saturation and hue adjustments, applied
+// in the LCh colour space. Brightness and Saturation are relative changes, where
+// zero leaves the image unchanged, -1 removes the lightness or the colours and 1
+// doubles them, and Hue rotates the hue by the given degrees.
+type Modulate struct {
+	Brightness float64
+	Saturation float64
+	Hue        float64
+}
+
+// Linear represents a linear adjustment of the pixel values. Contrast is the
+// relative change of the values around the middle of their range, where zero
+// leaves the image unchanged, -1 removes all contrast and 1 doubles it, and
+// Brightness shifts them by a fraction of their range, from -1 to 1.
+type Linear struct {
+	Contrast   float64
+	Brightness float64
+}
+
+// CLAHE represents the contrast limited adaptive histogram equalization, which
+// enhances the local contrast of the lightness within windows of the given size.
+// MaxSlope limits the contrast enhancement, where zero means no limit.
+type CLAHE struct {
+	Width    int
+	Height   int
+	MaxSlope int
+}
+
+// Duotone represents the mapping of the image lightness from the Shadows colour,
+// for black, to the Highlights colour, for white. It applies when both colours differ.
+type Duotone struct {
+	Shadows    Color
+	Highlights Color
+}
+
+// Convolve represents a convolution with a custom kernel of Width by Height
+// values, stored row by row. The result is divided by Scale, which defaults to
+// the sum of the kernel, or 1 if it is zero, and then shifted by Offset.
+type Convolve struct {
+	Width  int
+	Height int
+	Kernel []float64
+	Scale  float64
+	Offset float64
+}
+
+// Rank represents a rank filter, which replaces every pixel by the value picked
+// within the square window of Size pixels around it. Size defaults to 3.
+type Rank struct {
+	Filter RankFilter
+	Size   int
+}
+
+// Morphology represents a binary morphological operation, applied to every band
+// of the image, alpha included, after thresholding it at the middle of its range.
+// The structuring element is a Width by Height grid, stored row by row, which
+// defaults to a 3x3 square, and whose unset cells are ignored.
+type Morphology struct {
+	Operation MorphOperation
+	Width     int
+	Height    int
+	Element   []bool
+}
+
 // Sharpen represents the image sharp transformation options.
 type Sharpen struct {
 	Radius int
@@ -188,42 +595,74 @@ type Sharpen struct {
 
 // Options represents the supported image transformation options.
 type Options struct {
-	Height         int
-	Width          int
-	AreaHeight     int
-	AreaWidth      int
-	Top            int
-	Left           int
-	Quality        int
-	Compression    int
-	Zoom           int
-	Crop           bool
-	SmartCrop      bool // Deprecated, use: bimg.Options.Gravity = bimg.GravitySmart
-	Enlarge        bool
-	Embed          bool
-	Flip           bool
-	Flop           bool
-	Force          bool
-	NoAutoRotate   bool
-	NoProfile      bool
-	Interlace      bool
-	StripMetadata  bool
-	Trim           bool
-	Lossless       bool
-	Extend         Extend
-	Rotate         Angle
-	Background     Color
-	Gravity        Gravity
-	Watermark      Watermark
-	WatermarkImage WatermarkImage
-	Type           ImageType
-	Interpolator   Interpolator
-	Interpretation Interpretation
-	GaussianBlur   GaussianBlur
-	Sharpen        Sharpen
-	Threshold      float64
-	Gamma          float64
-	OutputICC      string
-	InputICC       string
-	Palette        bool
+	Height            int
+	Width             int
+	Scale             float64
+	DPR               float64
+	MaxPixels         int
+	AspectRatio       float64
+	AreaHeight        int
+	AreaWidth         int
+	Top               int
+	Left              int
+	Quality           int
+	Compression       int
+	Zoom              int
+	Crop              bool
+	SmartCrop         bool // Deprecated, use: bimg.Options.Gravity = bimg.GravitySmart
+	Enlarge           bool
+	Embed             bool
+	Flip              bool
+	Flop              bool
+	Force             bool
+	NoAutoRotate      bool
+	NoProfile         bool
+	Interlace         bool
+	StripMetadata     bool
+	Trim              bool
+	Lossless          bool
+	Extend            Extend
+	Rotate            Angle
+	Rotation          Rotation
+	Deskew            bool // Corrects the skew angle that SkewAngle reports for the same buffer
+	Affine            Affine
+	Perspective       Perspective
+	Padding           Padding
+	Border            Border
+	CornerRadius      int
+	Mask              Mask
+	Background        Color
+	Gravity           Gravity
+	FocalPoint        *FocalPoint
+	SmartCropStrategy SmartCropStrategy
+	Watermark         Watermark
+	WatermarkImage    WatermarkImage
+	Layers            []Layer
+	Caption           Caption
+	Redactions        []Redaction
+	Type              ImageType
+	Interpolator      Interpolator
+	Kernel            Kernel
+	LinearLight       bool
+	Interpretation    Interpretation
+	GaussianBlur      GaussianBlur
+	Rank              Rank
+	Morphology        Morphology
+	Sharpen           Sharpen
+	Convolve          Convolve
+	Emboss            bool
+	EdgeDetection     EdgeDetection
+	Modulate          Modulate
+	Linear            Linear
+	Normalize         Normalize
+	Equalize          bool
+	CLAHE             CLAHE
+	Filter            Filter
+	Tint              Color // Multiplies the image by the color in LAB
+	Duotone           Duotone
+	Threshold         float64
+	Gamma             float64
+	OutputICC         string
+	InputICC          string
+	Palette           bool
 }
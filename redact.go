@@ -0,0 +1,118 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"math"
+)
+
+// redactImage hides the given areas, which must already be mapped to the image
+// orientation. It runs before any resize, so the areas stay hidden whatever the
+// output size and the pixels never leak into the resized image.
+func redactImage(image *C.VipsImage, redactions []Redaction) (*C.VipsImage, error) {
+	var err error
+
+	for _, r := range redactions {
+		r, ok := calculateRedaction(r, int(image.Xsize), int(image.Ysize))
+		if !ok {
+			continue
+		}
+
+		image, err = vipsRedact(image, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return image, nil
+}
+
+// calculateRedaction clips the area of the redaction to the image and sets its
+// default strength. It reports false if nothing of the area is within the image.
+func calculateRedaction(r Redaction, width, height int) (Redaction, bool) {
+	left := int(math.Max(float64(r.Rect.Left), 0))
+	top := int(math.Max(float64(r.Rect.Top), 0))
+	right := int(math.Min(float64(r.Rect.Left+r.Rect.Width), float64(width)))
+	bottom := int(math.Min(float64(r.Rect.Top+r.Rect.Height), float64(height)))
+	if right <= left || bottom <= top {
+		return r, false
+	}
+	r.Rect = Rect{Left: left, Top: top, Width: right - left, Height: bottom - top}
+
+	if r.Strength <= 0 {
+		r.Strength = math.Max(math.Min(float64(r.Rect.Width), float64(r.Rect.Height))/10, 1)
+	}
+
+	return r, true
+}
+
+// calculateRedactionInk returns the fill color with one value per band of an
+// image of the given interpretation, converted to CMYK or gray if needed, and
+// scaled to the 16-bit range if needed. Alpha and extra bands are opaque.
+func calculateRedactionInk(c Color, interpretation Interpretation, bands int) []float64 {
+	max := 255.0
+	if interpretation == InterpretationRGB16 || interpretation == InterpretationGREY16 {
+		max = 65535
+	}
+
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+
+	var colour []float64
+	switch {
+	case interpretation == InterpretationCMYK && bands >= 4:
+		k := 1 - math.Max(r, math.Max(g, b))
+		colour = []float64{0, 0, 0, k}
+		if k < 1 {
+			colour = []float64{(1 - r - k) / (1 - k), (1 - g - k) / (1 - k), (1 - b - k) / (1 - k), k}
+		}
+	case bands >= 3:
+		colour = []float64{r, g, b}
+	default:
+		colour = []float64{(r + g + b) / 3}
+	}
+
+	ink := make([]float64, bands)
+	for i := range ink {
+		ink[i] = max
+		if i < len(colour) {
+			ink[i] = colour[i] * max
+		}
+	}
+	return ink
+}
+
+// rotateRedactions maps the areas of the redactions from the original image,
+// of the given size, through the same rotation and flip/flop steps applied by
+// rotateAndFlipImage.
+func rotateRedactions(redactions []Redaction, angle Angle, flip, flop bool, width, height int) []Redaction {
+	rotated := make([]Redaction, len(redactions))
+	for i, r := range redactions {
+		rotated[i] = r
+		rotated[i].Rect = rotateRect(r.Rect, angle, flip, flop, width, height)
+	}
+	return rotated
+}
+
+func rotateRect(r Rect, angle Angle, flip, flop bool, width, height int) Rect {
+	switch angle {
+	case D90:
+		r = Rect{Left: height - r.Top - r.Height, Top: r.Left, Width: r.Height, Height: r.Width}
+		width, height = height, width
+	case D180:
+		r.Left, r.Top = width-r.Left-r.Width, height-r.Top-r.Height
+	case D270:
+		r = Rect{Left: r.Top, Top: width - r.Left - r.Width, Width: r.Height, Height: r.Width}
+		width, height = height, width
+	}
+	if flip {
+		r.Left = width - r.Left - r.Width
+	}
+	if flop {
+		r.Top = height - r.Top - r.Height
+	}
+	return r
+}
@@ -0,0 +1,232 @@
+package bimg
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	// The top left quarter of the 1680x1050 image
+	area := Rect{Left: 0, Top: 0, Width: 840, Height: 525}
+
+	cases := []struct {
+		name      string
+		redaction Redaction
+	}{
+		{"fill", Redaction{Rect: area, Mode: RedactFill, Color: Color{255, 0, 0}}},
+		{"pixelate", Redaction{Rect: area, Mode: RedactPixelate, Strength: 210}},
+		{"blur", Redaction{Rect: area, Strength: 50}},
+	}
+
+	for _, tc := range cases {
+		newImg, err := Resize(buf, Options{Width: 800, Redactions: []Redaction{tc.redaction}})
+		if err != nil {
+			t.Errorf("%s: cannot redact the image: %s", tc.name, err)
+			continue
+		}
+
+		if err := assertSize(newImg, 800, 500); err != nil {
+			t.Error(err)
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(newImg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch tc.redaction.Mode {
+		case RedactFill:
+			if !isRed(img, 200, 125) {
+				t.Errorf("%s: expected the area to be filled", tc.name)
+			}
+			if isRed(img, 600, 375) {
+				t.Errorf("%s: expected the rest of the image to be left unchanged", tc.name)
+			}
+		default:
+			// The area has no detail left, the blocks being 100 pixels wide in the output
+			if d := colorDistance(img, image.Pt(20, 20), image.Pt(80, 80)); tc.redaction.Mode == RedactPixelate && d > 12 {
+				t.Errorf("%s: expected a uniform block, got a distance of %d", tc.name, d)
+			}
+		}
+	}
+}
+
+func TestRedactCMYK(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	cmyk, err := Resize(buf, Options{Width: 400, Interpretation: InterpretationCMYK})
+	if err != nil {
+		t.Skipf("Skipping this test, libvips cannot convert to CMYK: %s", err)
+	}
+	if interpretation, _ := ImageInterpretation(cmyk); interpretation != InterpretationCMYK {
+		t.Skipf("Skipping this test, libvips cannot convert to CMYK")
+	}
+
+	// The fill color is converted to the CMYK bands of the image
+	newImg, err := Resize(cmyk, Options{
+		Redactions: []Redaction{{Rect: Rect{Left: 0, Top: 0, Width: 200, Height: 125}, Mode: RedactFill, Color: Color{255, 0, 0}}},
+	})
+	if err != nil {
+		t.Fatalf("Cannot redact the CMYK image: %s", err)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(newImg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isRed(img, 100, 60) {
+		t.Errorf("Expected the area to be filled with red, got %v", img.At(100, 60))
+	}
+	if isRed(img, 300, 190) {
+		t.Error("Expected the rest of the image to be left unchanged")
+	}
+}
+
+func TestCalculateRedactionInk(t *testing.T) {
+	cases := []struct {
+		color          Color
+		interpretation Interpretation
+		bands          int
+		ink            []float64
+	}{
+		{Color{255, 0, 0}, InterpretationSRGB, 3, []float64{255, 0, 0}},
+		{Color{255, 0, 0}, InterpretationSRGB, 4, []float64{255, 0, 0, 255}},
+		{Color{30, 60, 90}, InterpretationBW, 1, []float64{60}},
+		{Color{30, 60, 90}, InterpretationBW, 2, []float64{60, 255}},
+		{Color{255, 0, 0}, InterpretationRGB16, 4, []float64{65535, 0, 0, 65535}},
+		{Color{255, 0, 0}, InterpretationCMYK, 4, []float64{0, 255, 255, 0}},
+		{Color{0, 0, 0}, InterpretationCMYK, 4, []float64{0, 0, 0, 255}},
+		{Color{255, 255, 255}, InterpretationCMYK, 5, []float64{0, 0, 0, 0, 255}},
+		{Color{255, 0, 0}, InterpretationMultiband, 6, []float64{255, 0, 0, 255, 255, 255}},
+	}
+
+	for _, tc := range cases {
+		ink := calculateRedactionInk(tc.color, tc.interpretation, tc.bands)
+		if len(ink) != len(tc.ink) {
+			t.Errorf("Invalid ink for %v with %d bands: got %v, expected %v", tc.color, tc.bands, ink, tc.ink)
+			continue
+		}
+		for i := range ink {
+			if math.Abs(ink[i]-tc.ink[i]) > 0.001 {
+				t.Errorf("Invalid ink for %v with %d bands: got %v, expected %v", tc.color, tc.bands, ink, tc.ink)
+				break
+			}
+		}
+	}
+}
+
+func TestRedactExifRotation(t *testing.T) {
+	// Landscape_6 is stored as 1200x1600 and rotated by 90 degrees to 1600x1200
+	buf, _ := Read("testdata/exif/Landscape_6.jpg")
+
+	newImg, err := Resize(buf, Options{
+		Width: 800,
+		Redactions: []Redaction{
+			{Rect: Rect{Left: 0, Top: 0, Width: 300, Height: 400}, Mode: RedactFill, Color: Color{255, 0, 0}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Cannot redact the image: %s", err)
+	}
+
+	if err := assertSize(newImg, 800, 600); err != nil {
+		t.Error(err)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(newImg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stored top left corner becomes the top right corner
+	if !isRed(img, 700, 75) {
+		t.Error("Expected the redaction in the top right corner")
+	}
+	if isRed(img, 100, 75) || isRed(img, 700, 300) {
+		t.Error("Expected the redaction only in the top right corner")
+	}
+}
+
+func TestCalculateRedaction(t *testing.T) {
+	cases := []struct {
+		redaction Redaction
+		expected  Redaction
+		ok        bool
+	}{
+		{Redaction{Rect: Rect{10, 20, 100, 50}}, Redaction{Rect: Rect{10, 20, 100, 50}, Strength: 5}, true},
+		{Redaction{Rect: Rect{-10, -20, 100, 50}, Strength: 3}, Redaction{Rect: Rect{0, 0, 90, 30}, Strength: 3}, true},
+		{Redaction{Rect: Rect{350, 250, 100, 100}}, Redaction{Rect: Rect{350, 250, 50, 50}, Strength: 5}, true},
+		{Redaction{Rect: Rect{0, 0, 4, 4}}, Redaction{Rect: Rect{0, 0, 4, 4}, Strength: 1}, true},
+		{Redaction{Rect: Rect{400, 0, 100, 100}}, Redaction{}, false},
+		{Redaction{Rect: Rect{0, 0, 0, 100}}, Redaction{}, false},
+	}
+
+	for _, tc := range cases {
+		r, ok := calculateRedaction(tc.redaction, 400, 300)
+		if ok != tc.ok {
+			t.Errorf("Invalid result for %+v: got %v", tc.redaction.Rect, ok)
+			continue
+		}
+		if ok && (r.Rect != tc.expected.Rect || r.Strength != tc.expected.Strength) {
+			t.Errorf("Invalid redaction for %+v: got %+v, expected %+v", tc.redaction.Rect, r, tc.expected)
+		}
+	}
+}
+
+func TestRotateRect(t *testing.T) {
+	// A 10x20 area at 1,2 of a 100x50 image
+	r := Rect{Left: 1, Top: 2, Width: 10, Height: 20}
+
+	cases := []struct {
+		angle      Angle
+		flip, flop bool
+		expected   Rect
+	}{
+		{D0, false, false, Rect{1, 2, 10, 20}},
+		{D90, false, false, Rect{28, 1, 20, 10}},
+		{D180, false, false, Rect{89, 28, 10, 20}},
+		{D270, false, false, Rect{2, 89, 20, 10}},
+		{D0, true, false, Rect{89, 2, 10, 20}},
+		{D0, false, true, Rect{1, 28, 10, 20}},
+		{D90, true, false, Rect{2, 1, 20, 10}},
+	}
+
+	for _, tc := range cases {
+		rect := rotateRect(r, tc.angle, tc.flip, tc.flop, 100, 50)
+		if rect != tc.expected {
+			t.Errorf("Invalid area for %v, flip %v, flop %v: got %+v, expected %+v", tc.angle, tc.flip, tc.flop, rect, tc.expected)
+		}
+	}
+
+	redactions := []Redaction{{Rect: r, Mode: RedactPixelate}}
+	rotated := rotateRedactions(redactions, D90, false, false, 100, 50)
+	if rotated[0].Rect != (Rect{28, 1, 20, 10}) || rotated[0].Mode != RedactPixelate {
+		t.Errorf("Invalid rotated redaction %+v", rotated[0])
+	}
+	if redactions[0].Rect != r {
+		t.Error("Expected the redactions of the caller to be left unchanged")
+	}
+}
+
+func isRed(img image.Image, x, y int) bool {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return r>>8 > 200 && g>>8 < 50 && b>>8 < 50
+}
+
+func colorDistance(img image.Image, p, q image.Point) int {
+	r1, g1, b1, _ := img.At(p.X, p.Y).RGBA()
+	r2, g2, b2, _ := img.At(q.X, q.Y).RGBA()
+	distance := 0
+	for _, d := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8)} {
+		if d < 0 {
+			d = -d
+		}
+		distance += d
+	}
+	return distance
+}
@@ -14,3 +14,10 @@ func Resize(buf []byte, o Options) ([]byte, error) {
 	defer runtime.KeepAlive(buf)
 	return resizer(buf, o)
 }
+
+// ResizeMany is used to transform a given image as byte buffer
+// into multiple outputs with the passed options, decoding it only once.
+func ResizeMany(buf []byte, opts []Options) ([]ResizedImage, error) {
+	defer runtime.KeepAlive(buf)
+	return resizerMany(buf, opts)
+}
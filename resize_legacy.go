@@ -8,3 +8,10 @@ package bimg
 func Resize(buf []byte, o Options) ([]byte, error) {
 	return resizer(buf, o)
 }
+
+// ResizeMany is used to transform a given image as byte buffer
+// into multiple outputs with the passed options, decoding it only once.
+// Used as proxy to resizerMany() only in Go <= 1.6 versions
+func ResizeMany(buf []byte, opts []Options) ([]ResizedImage, error) {
+	return resizerMany(buf, opts)
+}
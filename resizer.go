@@ -10,6 +10,8 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 )
 
 var (
@@ -17,6 +19,14 @@ var (
 	ErrExtractAreaParamsRequired = errors.New("extract area width/height params are required")
 )
 
+// ResizedImage represents an image rendered by ResizeMany.
+type ResizedImage struct {
+	Buffer []byte
+	Type   ImageType
+	Width  int
+	Height int
+}
+
 // resizer is used to transform a given image as byte buffer
 // with the passed options.
 func resizer(buf []byte, o Options) ([]byte, error) {
@@ -27,24 +37,134 @@ func resizer(buf []byte, o Options) ([]byte, error) {
 		return nil, err
 	}
 
+	// Plain resizes and crops take the faster libvips thumbnail path
+	if thumbnailOptions, ok := calculateThumbnail(image, o); ok {
+		C.g_object_unref(C.gpointer(image))
+		image, o, err = thumbnailImage(buf, imageType, thumbnailOptions)
+	} else {
+		image, o, err = resizeImage(image, buf, imageType, o)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return saveImage(image, o)
+}
+
+// resizerMany is used to transform a given image as byte buffer
+// into multiple outputs, decoding the image only once.
+func resizerMany(buf []byte, opts []Options) ([]ResizedImage, error) {
+	defer C.vips_thread_shutdown()
+
+	image, imageType, err := loadImage(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Shrink on load once, at the smallest reduction needed by any output
+	shrink := calculateSharedShrink(image, imageType, opts)
+	if shrink >= 2 {
+		image, _, err = shrinkOnLoad(buf, image, imageType, float64(shrink), shrink)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Decode the pixels once, so every output branches from the same image
+	image, err = vipsCopyMemory(image)
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	images := make([]ResizedImage, len(opts))
+	for i, o := range opts {
+		// Each output consumes its own reference to the decoded image
+		C.g_object_ref(C.gpointer(image))
+
+		output, o, err := resizeImage(image, nil, imageType, o)
+		if err != nil {
+			return nil, err
+		}
+
+		width, height := int(output.Xsize), int(output.Ysize)
+		data, err := saveImage(output, o)
+		if err != nil {
+			return nil, err
+		}
+
+		images[i] = ResizedImage{Buffer: data, Type: o.Type, Width: width, Height: height}
+	}
+
+	return images, nil
+}
+
+// resizeImage applies the transformations defined by the given options
+// to the image, returning it along with the options used to save it.
+// Shrink-on-load is disabled when no source buffer is given.
+func resizeImage(image *C.VipsImage, buf []byte, imageType ImageType, o Options) (*C.VipsImage, Options, error) {
+	var err error
+
 	// Clone and define default options
 	o = applyDefaults(o, imageType)
 
 	if !IsTypeSupported(o.Type) {
-		return nil, errors.New("Unsupported image output type")
+		C.g_object_unref(C.gpointer(image))
+		return nil, o, errors.New("Unsupported image output type")
 	}
 
 	// Auto rotate image based on EXIF orientation header
-	image, rotated, err := rotateAndFlipImage(image, o)
+	image, rotated, err := rotateAndFlipImage(image, &o)
 	if err != nil {
-		return nil, err
+		return nil, o, err
+	}
+
+	// Redact areas before any resize, so they are hidden at every output size
+	if len(o.Redactions) > 0 {
+		image, err = redactImage(image, o.Redactions)
+		if err != nil {
+			return nil, o, err
+		}
 	}
 
 	// If JPEG or HEIF image, retrieve the buffer
-	if rotated && (imageType == JPEG || imageType == HEIF) && !o.NoAutoRotate {
+	bufType := imageType
+	if len(o.Redactions) > 0 {
+		// The source buffer holds the redacted areas, so it cannot be reloaded
+		buf = nil
+	} else if rotated && (imageType == JPEG || imageType == HEIF) && !o.NoAutoRotate && buf != nil {
 		buf, err = getImageBuffer(image)
 		if err != nil {
-			return nil, err
+			return nil, o, err
+		}
+		bufType = JPEG
+	} else if rotated {
+		// The source buffer does not hold the rotation, so it cannot be reloaded
+		buf = nil
+	}
+
+	// Rotate image by an arbitrary angle or to correct its skew, if necessary
+	angle := calculateArbitraryAngle(o)
+	if o.Deskew {
+		skew, err := calculateSkew(image)
+		if err != nil {
+			return nil, o, err
+		}
+		angle -= skew
+	}
+	if angle != 0 {
+		image, err = rotateImageByAngle(image, &o, angle)
+		if err != nil {
+			return nil, o, err
+		}
+	}
+
+	// Apply affine or perspective transformation, if necessary
+	warped := shouldWarpImage(o)
+	if warped {
+		image, err = warpImage(image, o)
+		if err != nil {
+			return nil, o, err
 		}
 	}
 
@@ -59,6 +179,12 @@ func resizer(buf []byte, o Options) ([]byte, error) {
 	shrink := calculateShrink(factor, o.Interpolator)
 	residual := calculateResidual(factor, shrink)
 
+	// Box shrink averages pixels, so leave the whole reduction to the nearest kernel
+	if o.Kernel == KernelNearest {
+		shrink = 1
+		residual = calculateResidual(factor, shrink)
+	}
+
 	// Do not enlarge the output if the input width or height
 	// are already less than the required dimensions
 	if !o.Enlarge && !o.Force {
@@ -71,13 +197,12 @@ func resizer(buf []byte, o Options) ([]byte, error) {
 		}
 	}
 
-	// Try to use libjpeg/libwebp shrink-on-load
-	supportsShrinkOnLoad := imageType == WEBP && VipsMajorVersion >= 8 && VipsMinorVersion >= 3
-	supportsShrinkOnLoad = supportsShrinkOnLoad || imageType == JPEG
-	if supportsShrinkOnLoad && shrink >= 2 {
-		tmpImage, factor, err := shrinkOnLoad(buf, image, imageType, factor, shrink)
+	// Try to use the loader shrink-on-load
+	// The source buffer does not hold the arbitrary rotation or transformation
+	if supportsShrinkOnLoad(bufType) && buf != nil && angle == 0 && !warped && shrink >= 2 {
+		tmpImage, factor, err := shrinkOnLoad(buf, image, bufType, factor, shrink)
 		if err != nil {
-			return nil, err
+			return nil, o, err
 		}
 
 		image = tmpImage
@@ -89,14 +214,14 @@ func resizer(buf []byte, o Options) ([]byte, error) {
 	// Zoom image, if necessary
 	image, err = zoomImage(image, o.Zoom)
 	if err != nil {
-		return nil, err
+		return nil, o, err
 	}
 
 	// Transform image, if necessary
 	if shouldTransformImage(o, inWidth, inHeight) {
 		image, err = transformImage(image, o, shrink, residual)
 		if err != nil {
-			return nil, err
+			return nil, o, err
 		}
 	}
 
@@ -104,35 +229,61 @@ func resizer(buf []byte, o Options) ([]byte, error) {
 	if shouldApplyEffects(o) {
 		image, err = applyEffects(image, o)
 		if err != nil {
-			return nil, err
+			return nil, o, err
+		}
+	}
+
+	// Add padding and border, if necessary
+	image, err = padImage(image, o)
+	if err != nil {
+		return nil, o, err
+	}
+
+	// Apply rounded corners or shape mask, if necessary
+	if shouldApplyMask(o) {
+		image, err = maskImage(image, o)
+		if err != nil {
+			return nil, o, err
 		}
 	}
 
+	// Composite layers over the image, if necessary
+	image, err = compositeImage(image, o.Layers)
+	if err != nil {
+		return nil, o, err
+	}
+
+	// Render caption, if necessary
+	image, err = captionImage(image, o.Caption)
+	if err != nil {
+		return nil, o, err
+	}
+
 	// Add watermark, if necessary
 	image, err = watermarkImageWithText(image, o.Watermark)
 	if err != nil {
-		return nil, err
+		return nil, o, err
 	}
 
 	// Add watermark, if necessary
 	image, err = watermarkImageWithAnotherImage(image, o.WatermarkImage)
 	if err != nil {
-		return nil, err
+		return nil, o, err
 	}
 
 	// Flatten image on a background, if necessary
 	image, err = imageFlatten(image, imageType, o)
 	if err != nil {
-		return nil, err
+		return nil, o, err
 	}
 
 	// Apply Gamma filter, if necessary
 	image, err = applyGamma(image, o)
 	if err != nil {
-		return nil, err
+		return nil, o, err
 	}
 
-	return saveImage(image, o)
+	return image, o, nil
 }
 
 func loadImage(buf []byte) (*C.VipsImage, ImageType, error) {
@@ -160,6 +311,10 @@ func applyDefaults(o Options, imageType ImageType) Options {
 	}
 	if o.Interpretation == 0 {
 		o.Interpretation = InterpretationSRGB
+		// Keep grayscale images with a single band, plus their alpha band
+		if o.Filter == FilterGrayscale && o.Tint == (Color{}) && o.Duotone.Shadows == o.Duotone.Highlights {
+			o.Interpretation = InterpretationBW
+		}
 	}
 	return o
 }
@@ -183,6 +338,10 @@ func saveImage(image *C.VipsImage, o Options) ([]byte, error) {
 }
 
 func normalizeOperation(o *Options, inWidth, inHeight int) {
+	if o.AspectRatio > 0 && (o.Width == 0 || o.Height == 0) {
+		calculateAspectRatioCrop(o, inWidth, inHeight)
+	}
+
 	if !o.Force && !o.Crop && !o.Embed && !o.Enlarge && o.Rotate == 0 && (o.Width > 0 || o.Height > 0) {
 		o.Force = true
 	}
@@ -194,12 +353,57 @@ func shouldTransformImage(o Options, inWidth, inHeight int) bool {
 		o.Trim
 }
 
+func shouldApplyMask(o Options) bool {
+	return o.CornerRadius > 0 || o.Mask.Shape != MaskNone || len(o.Mask.Buf) > 0
+}
+
 func shouldApplyEffects(o Options) bool {
-	return o.GaussianBlur.Sigma > 0 || o.GaussianBlur.MinAmpl > 0 || o.Sharpen.Radius > 0 && o.Sharpen.Y2 > 0 || o.Sharpen.Y3 > 0
+	return o.Rank.Filter != RankNone || o.Morphology.Operation != MorphNone ||
+		o.GaussianBlur.Sigma > 0 || o.GaussianBlur.MinAmpl > 0 || o.Sharpen.Radius > 0 && o.Sharpen.Y2 > 0 || o.Sharpen.Y3 > 0 ||
+		len(o.Convolve.Kernel) > 0 || o.Emboss || o.EdgeDetection != EdgeNone ||
+		o.Modulate != (Modulate{}) || o.Linear != (Linear{}) ||
+		o.Normalize != NormalizeNone || o.Equalize || o.CLAHE.Width > 0 && o.CLAHE.Height > 0 ||
+		o.Filter != FilterNone || o.Tint != (Color{}) || o.Duotone.Shadows != o.Duotone.Highlights
+}
+
+// scaleImage resizes the image to the size defined by the given options,
+// using the same calculations as the transformation pipeline.
+func scaleImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
+	inWidth := int(image.Xsize)
+	inHeight := int(image.Ysize)
+
+	normalizeOperation(&o, inWidth, inHeight)
+	factor := imageCalculations(&o, inWidth, inHeight)
+	shrink := calculateShrink(factor, o.Interpolator)
+	residual := calculateResidual(factor, shrink)
+
+	return transformImage(image, o, shrink, residual)
 }
 
 func transformImage(image *C.VipsImage, o Options, shrink int, residual float64) (*C.VipsImage, error) {
 	var err error
+	resample := shrink > 1 || o.Force || residual != 0
+
+	// Resample in linear light, if necessary
+	interpretation := vipsInterpretation(image)
+	linear := o.LinearLight && resample && vipsColourspaceIsSupported(image)
+	if linear {
+		image, err = vipsColourspace(image, InterpretationScRGB)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Premultiply alpha, so transparent pixels don't bleed into their neighbours
+	format := image.BandFmt
+	premultiplied := resample && vipsHasAlpha(image)
+	if premultiplied {
+		image, err = vipsPremultiply(image)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Use vips_shrink with the integral reduction
 	if shrink > 1 {
 		image, residual, err = shrinkImage(image, o, residual, shrink)
@@ -216,10 +420,28 @@ func transformImage(image *C.VipsImage, o Options, shrink int, residual float64)
 
 	if o.Force || residual != 0 {
 		if residualx < 1 && residualy < 1 {
-			image, err = vipsReduce(image, 1/residualx, 1/residualy)
+			image, err = vipsReduce(image, 1/residualx, 1/residualy, o.Kernel)
 		} else {
-			image, err = vipsAffine(image, residualx, residualy, o.Interpolator, o.Extend)
+			interpolator := o.Interpolator
+			if o.Kernel == KernelNearest {
+				interpolator = Nearest
+			}
+			image, err = vipsAffine(image, residualx, residualy, interpolator, o.Extend)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if premultiplied {
+		image, err = vipsUnpremultiply(image, format)
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	if linear {
+		image, err = vipsColourspace(image, interpretation)
 		if err != nil {
 			return nil, err
 		}
@@ -241,6 +463,20 @@ func transformImage(image *C.VipsImage, o Options, shrink int, residual float64)
 func applyEffects(image *C.VipsImage, o Options) (*C.VipsImage, error) {
 	var err error
 
+	if o.Rank.Filter != RankNone {
+		image, err = vipsRank(image, o.Rank)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Morphology.Operation != MorphNone {
+		image, err = vipsMorphology(image, o.Morphology)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if o.GaussianBlur.Sigma > 0 || o.GaussianBlur.MinAmpl > 0 {
 		image, err = vipsGaussianBlur(image, o.GaussianBlur)
 		if err != nil {
@@ -255,6 +491,159 @@ func applyEffects(image *C.VipsImage, o Options) (*C.VipsImage, error) {
 		}
 	}
 
+	if len(o.Convolve.Kernel) > 0 {
+		image, err = vipsConvolve(image, o.Convolve)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Emboss {
+		image, err = vipsConvolve(image, embossKernel)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.EdgeDetection != EdgeNone {
+		image, err = vipsEdgeDetection(image, o.EdgeDetection)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Normalize != NormalizeNone {
+		image, err = vipsNormalize(image, o.Normalize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Equalize {
+		image, err = vipsEqualize(image)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.CLAHE.Width > 0 && o.CLAHE.Height > 0 {
+		image, err = vipsCLAHE(image, o.CLAHE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Modulate != (Modulate{}) {
+		image, err = vipsModulate(image, o.Modulate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Linear != (Linear{}) {
+		image, err = vipsLinear(image, o.Linear)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return applyColourFilters(image, o)
+}
+
+// calculateRank returns the window size of the rank filter, and the index of
+// the value it picks among the sorted values of the window.
+func calculateRank(r Rank) (int, int) {
+	size := r.Size
+	if size <= 0 {
+		size = 3
+	}
+
+	switch r.Filter {
+	case RankMin:
+		return size, 0
+	case RankMax:
+		return size, size*size - 1
+	}
+	return size, size * size / 2
+}
+
+// calculateMorphologyMask returns the size and the values of the libvips
+// morphology mask, where 255 cells must be set and 128 cells are ignored.
+func calculateMorphologyMask(m Morphology) (int, int, []float64, error) {
+	width, height := m.Width, m.Height
+	if width <= 0 || height <= 0 {
+		width, height = 3, 3
+	}
+
+	if m.Element != nil && len(m.Element) != width*height {
+		return 0, 0, nil, errors.New("Morphology element size does not match its width and height")
+	}
+
+	mask := make([]float64, width*height)
+	for i := range mask {
+		mask[i] = 255
+		if m.Element != nil && !m.Element[i] {
+			mask[i] = 128
+		}
+	}
+	return width, height, mask, nil
+}
+
+// embossKernel lights the image from its top left corner.
+var embossKernel = Convolve{
+	Width:  3,
+	Height: 3,
+	Kernel: []float64{
+		-2, -1, 0,
+		-1, 1, 1,
+		0, 1, 2,
+	},
+}
+
+// calculateConvolveScale returns the scale of the convolution, which defaults
+// to the sum of the kernel, so the brightness of the image is preserved.
+func calculateConvolveScale(c Convolve) float64 {
+	if c.Scale != 0 {
+		return c.Scale
+	}
+
+	sum := 0.0
+	for _, value := range c.Kernel {
+		sum += value
+	}
+	if sum == 0 {
+		return 1
+	}
+	return sum
+}
+
+func applyColourFilters(image *C.VipsImage, o Options) (*C.VipsImage, error) {
+	var err error
+
+	switch o.Filter {
+	case FilterGrayscale:
+		image, err = vipsColourFilter(image, C.FILTER_GRAYSCALE, Color{}, Color{})
+	case FilterSepia:
+		image, err = vipsColourFilter(image, C.FILTER_SEPIA, Color{}, Color{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Tint != (Color{}) {
+		image, err = vipsColourFilter(image, C.FILTER_TINT, o.Tint, Color{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Duotone.Shadows != o.Duotone.Highlights {
+		image, err = vipsColourFilter(image, C.FILTER_DUOTONE, o.Duotone.Shadows, o.Duotone.Highlights)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return image, nil
 }
 
@@ -271,7 +660,7 @@ func extractOrEmbedImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
 		}
 		width := int(math.Min(float64(inWidth), float64(o.Width)))
 		height := int(math.Min(float64(inHeight), float64(o.Height)))
-		image, err = vipsSmartCrop(image, width, height)
+		image, err = vipsSmartCrop(image, width, height, o.SmartCropStrategy)
 		break
 	case o.Crop:
 		// it's already at an appropriate size, return immediately
@@ -281,11 +670,14 @@ func extractOrEmbedImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
 		width := int(math.Min(float64(inWidth), float64(o.Width)))
 		height := int(math.Min(float64(inHeight), float64(o.Height)))
 		left, top := calculateCrop(inWidth, inHeight, o.Width, o.Height, o.Gravity)
+		if o.FocalPoint != nil {
+			left, top = calculateFocalPointCrop(inWidth, inHeight, width, height, *o.FocalPoint)
+		}
 		left, top = int(math.Max(float64(left), 0)), int(math.Max(float64(top), 0))
 		image, err = vipsExtract(image, left, top, width, height)
 		break
 	case o.Embed:
-		left, top := (o.Width-inWidth)/2, (o.Height-inHeight)/2
+		left, top := calculateEmbed(inWidth, inHeight, o.Width, o.Height, o.Gravity)
 		image, err = vipsEmbed(image, left, top, o.Width, o.Height, o.Extend, o.Background)
 		break
 	case o.Trim:
@@ -311,26 +703,82 @@ func extractOrEmbedImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
 	return image, err
 }
 
-func rotateAndFlipImage(image *C.VipsImage, o Options) (*C.VipsImage, bool, error) {
+func padImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
+	var err error
+
+	if o.Padding != (Padding{}) {
+		width, height := int(image.Xsize), int(image.Ysize)
+		top, right, bottom, left := calculatePadding(o.Padding, width, height)
+		image, err = vipsEmbed(image, left, top, width+left+right, height+top+bottom, o.Extend, o.Background)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Border.Width > 0 {
+		image, err = vipsBorder(image, o.Border.Width, o.Border.Color)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return image, nil
+}
+
+func calculatePadding(p Padding, width, height int) (int, int, int, int) {
+	top, right, bottom, left := p.Top, p.Right, p.Bottom, p.Left
+	if p.Percent {
+		top = roundFloat(float64(height*p.Top) / 100)
+		right = roundFloat(float64(width*p.Right) / 100)
+		bottom = roundFloat(float64(height*p.Bottom) / 100)
+		left = roundFloat(float64(width*p.Left) / 100)
+	}
+	return max(top), max(right), max(bottom), max(left)
+}
+
+func maskImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
+	var mask *C.VipsImage
+	var err error
+	width, height := int(image.Xsize), int(image.Ysize)
+
+	if len(o.Mask.Buf) > 0 {
+		mask, err = vipsMaskFromBuffer(o.Mask.Buf, width, height)
+	} else {
+		shape := o.Mask.Shape
+		if shape == MaskNone {
+			shape = MaskRoundedRect
+		}
+		mask, err = vipsMaskFromShape(shape, width, height, o.CornerRadius)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return vipsApplyMask(image, mask)
+}
+
+func rotateAndFlipImage(image *C.VipsImage, o *Options) (*C.VipsImage, bool, error) {
 	var err error
 	var rotated bool
+	inWidth, inHeight := int(image.Xsize), int(image.Ysize)
 
+	rotate, flip := o.Rotate, o.Flip
 	if o.NoAutoRotate == false {
-		rotation, flip := calculateRotationAndFlip(image, o.Rotate)
-		if flip {
-			o.Flip = flip
+		rotation, exifFlip := calculateRotationAndFlip(image, o.Rotate)
+		if exifFlip {
+			flip = exifFlip
 		}
-		if rotation > 0 && o.Rotate == 0 {
-			o.Rotate = rotation
+		if rotation > 0 && rotate == 0 {
+			rotate = rotation
 		}
 	}
 
-	if o.Rotate > 0 {
+	if rotate > 0 {
 		rotated = true
-		image, err = vipsRotate(image, getAngle(o.Rotate))
+		image, err = vipsRotate(image, getAngle(rotate))
 	}
 
-	if o.Flip {
+	if flip {
 		rotated = true
 		image, err = vipsFlip(image, Horizontal)
 	}
@@ -339,9 +787,78 @@ func rotateAndFlipImage(image *C.VipsImage, o Options) (*C.VipsImage, bool, erro
 		rotated = true
 		image, err = vipsFlip(image, Vertical)
 	}
+
+	// Keep the focal point attached to the same image content
+	// A new value is set, so the focal point of the caller is left unchanged
+	if o.FocalPoint != nil {
+		p := rotateFocalPoint(*o.FocalPoint, getAngle(rotate), flip, o.Flop)
+		o.FocalPoint = &p
+	}
+
+	// Keep the redacted areas on the same image content
+	if len(o.Redactions) > 0 {
+		o.Redactions = rotateRedactions(o.Redactions, getAngle(rotate), flip, o.Flop, inWidth, inHeight)
+	}
+
 	return image, rotated, err
 }
 
+// rotateFocalPoint maps a relative focal point through the same rotation
+// and flip/flop steps applied by rotateAndFlipImage.
+func rotateFocalPoint(p FocalPoint, angle Angle, flip, flop bool) FocalPoint {
+	switch angle {
+	case D90:
+		p.X, p.Y = 1-p.Y, p.X
+	case D180:
+		p.X, p.Y = 1-p.X, 1-p.Y
+	case D270:
+		p.X, p.Y = p.Y, 1-p.X
+	}
+	if flip {
+		p.X = 1 - p.X
+	}
+	if flop {
+		p.Y = 1 - p.Y
+	}
+	return p
+}
+
+func rotateImageByAngle(image *C.VipsImage, o *Options, angle float64) (*C.VipsImage, error) {
+	inWidth := float64(image.Xsize)
+	inHeight := float64(image.Ysize)
+
+	image, err := vipsSimilarity(image, angle, o.Rotation.Interpolator, o.Background, o.Rotation.Transparent)
+	if err != nil {
+		return nil, err
+	}
+
+	rotatedWidth := float64(image.Xsize)
+	rotatedHeight := float64(image.Ysize)
+	left, top := 0.0, 0.0
+
+	if o.Rotation.Crop {
+		width, height := calculateInscribedRect(inWidth, inHeight, angle)
+		width, height = math.Max(math.Floor(width), 1), math.Max(math.Floor(height), 1)
+		left, top = math.Floor((rotatedWidth-width)/2), math.Floor((rotatedHeight-height)/2)
+
+		image, err = vipsExtract(image, int(left), int(top), int(width), int(height))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep the focal point attached to the same image content
+	if o.FocalPoint != nil {
+		x, y := rotatePoint(o.FocalPoint.X*inWidth-inWidth/2, o.FocalPoint.Y*inHeight-inHeight/2, angle)
+		o.FocalPoint = &FocalPoint{
+			X: (x + rotatedWidth/2 - left) / float64(image.Xsize),
+			Y: (y + rotatedHeight/2 - top) / float64(image.Ysize),
+		}
+	}
+
+	return image, nil
+}
+
 func watermarkImageWithText(image *C.VipsImage, w Watermark) (*C.VipsImage, error) {
 	if w.Text == "" {
 		return image, nil
@@ -357,7 +874,7 @@ func watermarkImageWithText(image *C.VipsImage, w Watermark) (*C.VipsImage, erro
 	if w.DPI == 0 {
 		w.DPI = 150
 	}
-	if w.Margin == 0 {
+	if w.Margin == 0 && !w.UseGravity {
 		w.Margin = w.Width
 	}
 	if w.Opacity == 0 {
@@ -366,6 +883,11 @@ func watermarkImageWithText(image *C.VipsImage, w Watermark) (*C.VipsImage, erro
 		w.Opacity = 1
 	}
 
+	// Colors, outline, alignment, placement, rotation and font files need a text layer
+	if shouldUseTextLayer(w) {
+		return watermarkImageWithTextLayer(image, w)
+	}
+
 	image, err := vipsWatermark(image, w)
 	if err != nil {
 		return nil, err
@@ -393,8 +915,60 @@ func watermarkImageWithAnotherImage(image *C.VipsImage, w WatermarkImage) (*C.Vi
 	return image, nil
 }
 
+// placeWatermarkImage scales and tiles the watermark, if necessary, returning
+// it along with its position on the image. The watermark is consumed.
+func placeWatermarkImage(image *C.VipsImage, watermark *C.VipsImage, w WatermarkImage) (*C.VipsImage, int, int, error) {
+	var err error
+	inWidth := int(image.Xsize)
+	inHeight := int(image.Ysize)
+
+	// Scale the watermark relative to the image width
+	if w.Scale > 0 {
+		width := int(math.Max(float64(roundFloat(float64(inWidth)*w.Scale/100)), 1))
+		watermark, err = scaleImage(watermark, Options{Width: width})
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	if w.Replicate {
+		watermark, err = vipsWatermarkTile(watermark, inWidth, inHeight, w.Margin)
+		return watermark, 0, 0, err
+	}
+
+	if !w.UseGravity {
+		return watermark, w.Left, w.Top, nil
+	}
+
+	left, top := calculateWatermarkPosition(inWidth, inHeight, int(watermark.Xsize), int(watermark.Ysize), w.Gravity, w.Margin)
+	return watermark, left, top, nil
+}
+
+// calculateWatermarkPosition places the watermark according to its gravity,
+// keeping the margin from the image edges it is aligned with.
+func calculateWatermarkPosition(inWidth, inHeight, width, height int, gravity Gravity, margin int) (int, int) {
+	left, top := calculateEmbed(width, height, inWidth, inHeight, gravity)
+
+	switch gravity {
+	case GravityWest, GravityNorthWest, GravitySouthWest:
+		left += margin
+	case GravityEast, GravityNorthEast, GravitySouthEast:
+		left -= margin
+	}
+
+	switch gravity {
+	case GravityNorth, GravityNorthEast, GravityNorthWest:
+		top += margin
+	case GravitySouth, GravitySouthEast, GravitySouthWest:
+		top -= margin
+	}
+
+	return left, top
+}
+
 func imageFlatten(image *C.VipsImage, imageType ImageType, o Options) (*C.VipsImage, error) {
-	if o.Background == ColorBlack {
+	// Masked images must be flattened when the output format has no alpha channel
+	if o.Background == ColorBlack && !(o.Type == JPEG && shouldApplyMask(o)) {
 		return image, nil
 	}
 	return vipsFlattenBackground(image, o.Background)
@@ -438,9 +1012,72 @@ func shrinkImage(image *C.VipsImage, o Options, residual float64, shrink int) (*
 	return image, residual, nil
 }
 
+func supportsShrinkOnLoad(imageType ImageType) bool {
+	switch imageType {
+	case JPEG, TIFF:
+		return true
+	case WEBP:
+		return VipsMajorVersion >= 8 && VipsMinorVersion >= 3
+	case PDF, SVG:
+		return VipsMajorVersion >= 8 && VipsMinorVersion >= 7
+	case HEIF:
+		return VipsMajorVersion >= 8 && VipsMinorVersion >= 8
+	}
+	return false
+}
+
+// calculateSharedShrink returns the shrink-on-load factor that suits all
+// the given outputs, which is the one of the least reduced output.
+// Outputs referring to input coordinates, sized relatively to the input or
+// transforming the image before resizing need the full resolution image.
+func calculateSharedShrink(image *C.VipsImage, imageType ImageType, opts []Options) int {
+	if !supportsShrinkOnLoad(imageType) || len(opts) == 0 {
+		return 1
+	}
+
+	shrink := 0
+	for _, o := range opts {
+		if o.Top != 0 || o.Left != 0 || o.AreaWidth != 0 || o.AreaHeight != 0 ||
+			o.Deskew || calculateArbitraryAngle(o) != 0 || shouldWarpImage(o) || o.Kernel == KernelNearest ||
+			len(o.Redactions) > 0 {
+			return 1
+		}
+
+		// Scales and aspect ratio crops without dimensions are resolved on the input size
+		if (o.Scale > 0 || o.AspectRatio > 0) && o.Width == 0 && o.Height == 0 {
+			return 1
+		}
+
+		// The outputs are calculated on the auto rotated image
+		inWidth, inHeight := int(image.Xsize), int(image.Ysize)
+		rotate := getAngle(o.Rotate)
+		if o.Rotate == 0 && !o.NoAutoRotate {
+			rotate, _ = calculateRotationAndFlip(image, o.Rotate)
+		}
+		if rotate == D90 || rotate == D270 {
+			inWidth, inHeight = inHeight, inWidth
+		}
+
+		normalizeOperation(&o, inWidth, inHeight)
+		factor := imageCalculations(&o, inWidth, inHeight)
+		outputShrink := calculateShrink(factor, o.Interpolator)
+		if !o.Enlarge && !o.Force && inWidth < o.Width && inHeight < o.Height {
+			outputShrink = 1
+		}
+
+		if shrink == 0 || outputShrink < shrink {
+			shrink = outputShrink
+		}
+	}
+
+	return shrink
+}
+
 func shrinkOnLoad(buf []byte, input *C.VipsImage, imageType ImageType, factor float64, shrink int) (*C.VipsImage, float64, error) {
 	var image *C.VipsImage
 	var err error
+	inWidth := float64(input.Xsize)
+	inHeight := float64(input.Ysize)
 
 	// Reload input using shrink-on-load
 	if imageType == JPEG && shrink >= 2 {
@@ -461,15 +1098,55 @@ func shrinkOnLoad(buf []byte, input *C.VipsImage, imageType ImageType, factor fl
 		image, err = vipsShrinkJpeg(buf, input, shrinkOnLoad)
 	} else if imageType == WEBP {
 		image, err = vipsShrinkWebp(buf, input, shrink)
+	} else if imageType == HEIF {
+		width := int(math.Ceil(inWidth / factor))
+		height := int(math.Ceil(inHeight / factor))
+		image, err = vipsShrinkHeif(buf, input, width, height)
+	} else if imageType == PDF {
+		image, err = vipsShrinkPdf(buf, input, shrink)
+	} else if imageType == SVG {
+		image, err = vipsShrinkSvg(buf, input, shrink)
+	} else if imageType == TIFF {
+		image, err = vipsShrinkTiff(buf, input, shrink)
 	} else {
 		return nil, 0, fmt.Errorf("%v doesn't support shrink on load", ImageTypeName(imageType))
 	}
 
+	// The embedded thumbnail, vector scale and pyramid level sizes
+	// depend on the file, so recalculate the factor from the loaded size
+	if err == nil && imageType != JPEG && imageType != WEBP {
+		factor = factor * float64(image.Xsize) / inWidth
+	}
+
 	return image, factor, err
 }
 
 func imageCalculations(o *Options, inWidth, inHeight int) float64 {
 	factor := 1.0
+
+	// Percentage of the input size, when no explicit size is given
+	if o.Scale > 0 && o.Width == 0 && o.Height == 0 {
+		o.Width = roundFloat(float64(inWidth) * o.Scale / 100)
+		o.Height = roundFloat(float64(inHeight) * o.Scale / 100)
+		o.Enlarge = o.Enlarge || o.Scale > 100
+	}
+
+	// Device pixel ratio, capped at the input size unless enlarging
+	if o.DPR > 0 && o.DPR != 1 {
+		dpr := o.DPR
+		if !o.Enlarge {
+			if o.Width > 0 {
+				dpr = math.Min(dpr, float64(inWidth)/float64(o.Width))
+			}
+			if o.Height > 0 {
+				dpr = math.Min(dpr, float64(inHeight)/float64(o.Height))
+			}
+			dpr = math.Max(dpr, math.Min(o.DPR, 1))
+		}
+		o.Width = roundFloat(float64(o.Width) * dpr)
+		o.Height = roundFloat(float64(o.Height) * dpr)
+	}
+
 	xfactor := float64(inWidth) / float64(o.Width)
 	yfactor := float64(inHeight) / float64(o.Height)
 
@@ -504,9 +1181,71 @@ func imageCalculations(o *Options, inWidth, inHeight int) float64 {
 		break
 	}
 
+	// Fit the output within the pixels budget, keeping its aspect ratio
+	if o.MaxPixels > 0 && o.Width*o.Height > o.MaxPixels {
+		scale := math.Sqrt(float64(o.MaxPixels) / float64(o.Width*o.Height))
+		o.Width = int(math.Max(math.Floor(float64(o.Width)*scale), 1))
+		o.Height = int(math.Max(math.Floor(float64(o.Height)*scale), 1))
+		factor = factor / scale
+	}
+
 	return factor
 }
 
+// ParseAspectRatio parses an aspect ratio expressed as "width:height",
+// such as "16:9", or as a decimal number, such as "1.5".
+func ParseAspectRatio(ratio string) (float64, error) {
+	var value float64
+	var err error
+
+	if parts := strings.Split(ratio, ":"); len(parts) == 2 {
+		var width, height float64
+		width, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err == nil {
+			height, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		}
+		if err == nil && height > 0 {
+			value = width / height
+		}
+	} else {
+		value, err = strconv.ParseFloat(strings.TrimSpace(ratio), 64)
+	}
+
+	if err != nil || value <= 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("Invalid aspect ratio: %q", ratio)
+	}
+	return value, nil
+}
+
+// calculateAspectRatioCrop turns an aspect ratio into a crop of the largest
+// region with that ratio, scaled down to the given width or height, if any.
+func calculateAspectRatioCrop(o *Options, inWidth, inHeight int) {
+	width, height := float64(inWidth), float64(inHeight)
+	if width/height > o.AspectRatio {
+		width = height * o.AspectRatio
+	} else {
+		height = width / o.AspectRatio
+	}
+
+	switch {
+	case o.Width > 0:
+		o.Height = int(math.Max(float64(roundFloat(float64(o.Width)/o.AspectRatio)), 1))
+	case o.Height > 0:
+		o.Width = int(math.Max(float64(roundFloat(float64(o.Height)*o.AspectRatio)), 1))
+	default:
+		if o.Scale > 0 {
+			width = width * o.Scale / 100
+			height = height * o.Scale / 100
+			o.Enlarge = o.Enlarge || o.Scale > 100
+		}
+		o.Width = int(math.Max(float64(roundFloat(width)), 1))
+		o.Height = int(math.Max(float64(roundFloat(height)), 1))
+	}
+
+	o.Crop = true
+	o.Embed = false
+}
+
 func roundFloat(f float64) int {
 	if f < 0 {
 		return int(math.Ceil(f - 0.5))
@@ -528,6 +1267,15 @@ func calculateCrop(inWidth, inHeight, outWidth, outHeight int, gravity Gravity)
 		top = inHeight - outHeight
 	case GravityWest:
 		top = (inHeight - outHeight + 1) / 2
+	case GravityNorthEast:
+		left = inWidth - outWidth
+	case GravityNorthWest:
+		break
+	case GravitySouthEast:
+		left = inWidth - outWidth
+		top = inHeight - outHeight
+	case GravitySouthWest:
+		top = inHeight - outHeight
 	default:
 		left = (inWidth - outWidth + 1) / 2
 		top = (inHeight - outHeight + 1) / 2
@@ -536,6 +1284,45 @@ func calculateCrop(inWidth, inHeight, outWidth, outHeight int, gravity Gravity)
 	return left, top
 }
 
+func calculateEmbed(inWidth, inHeight, outWidth, outHeight int, gravity Gravity) (int, int) {
+	left, top := (outWidth-inWidth)/2, (outHeight-inHeight)/2
+
+	switch gravity {
+	case GravityNorth:
+		top = 0
+	case GravityEast:
+		left = outWidth - inWidth
+	case GravitySouth:
+		top = outHeight - inHeight
+	case GravityWest:
+		left = 0
+	case GravityNorthEast:
+		left, top = outWidth-inWidth, 0
+	case GravityNorthWest:
+		left, top = 0, 0
+	case GravitySouthEast:
+		left, top = outWidth-inWidth, outHeight-inHeight
+	case GravitySouthWest:
+		left, top = 0, outHeight-inHeight
+	}
+
+	return left, top
+}
+
+func calculateFocalPointCrop(inWidth, inHeight, outWidth, outHeight int, p FocalPoint) (int, int) {
+	x := math.Min(math.Max(p.X, 0), 1) * float64(inWidth)
+	y := math.Min(math.Max(p.Y, 0), 1) * float64(inHeight)
+
+	left := roundFloat(x - float64(outWidth)/2)
+	top := roundFloat(y - float64(outHeight)/2)
+
+	// Keep the crop area within the image bounds
+	left = int(math.Max(math.Min(float64(left), float64(inWidth-outWidth)), 0))
+	top = int(math.Max(math.Min(float64(top), float64(inHeight-outHeight)), 0))
+
+	return left, top
+}
+
 func calculateRotationAndFlip(image *C.VipsImage, angle Angle) (Angle, bool) {
 	rotate := D0
 	flip := false
@@ -593,6 +1380,48 @@ func calculateResidual(factor float64, shrink int) float64 {
 	return float64(shrink) / factor
 }
 
+// calculateArbitraryAngle returns the rotation left after the right angle
+// rotation of Options.Rotate, plus the Options.Rotation angle.
+func calculateArbitraryAngle(o Options) float64 {
+	angle := o.Rotate
+	if angle > 0 {
+		angle -= getAngle(angle)
+	}
+	return float64(angle) + math.Mod(o.Rotation.Angle, 360)
+}
+
+// calculateInscribedRect returns the size of the largest axis-aligned rectangle
+// that fits within a width x height rectangle rotated by the given degrees.
+func calculateInscribedRect(width, height, angle float64) (float64, float64) {
+	if width <= 0 || height <= 0 {
+		return 0, 0
+	}
+
+	long, short := math.Max(width, height), math.Min(width, height)
+	radians := angle * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(radians)), math.Abs(math.Cos(radians))
+
+	// Half constrained case: two crop corners touch the longer side
+	if short <= 2*sin*cos*long || math.Abs(sin-cos) < 1e-10 {
+		x := 0.5 * short
+		if width >= height {
+			return x / sin, x / cos
+		}
+		return x / cos, x / sin
+	}
+
+	// Fully constrained case: the crop touches all four sides
+	cos2 := cos*cos - sin*sin
+	return (width*cos - height*sin) / cos2, (height*cos - width*sin) / cos2
+}
+
+// rotatePoint rotates a point around the origin by the given clockwise degrees.
+func rotatePoint(x, y, angle float64) (float64, float64) {
+	radians := angle * math.Pi / 180
+	sin, cos := math.Sin(radians), math.Cos(radians)
+	return x*cos - y*sin, x*sin + y*cos
+}
+
 func getAngle(angle Angle) Angle {
 	divisor := angle % 90
 	if divisor != 0 {
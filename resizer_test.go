@@ -5,8 +5,11 @@ import (
 	"crypto/md5"
 	"fmt"
 	"image"
+	"image/color"
 	"image/jpeg"
+	"image/png"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"testing"
@@ -660,6 +663,1407 @@ func TestSkipCropIfTooSmall(t *testing.T) {
 	}
 }
 
+func TestFocalPointCrop(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	options := Options{Width: 300, Height: 300, Crop: true, FocalPoint: &FocalPoint{X: 0.9, Y: 0.1}}
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	size, _ := Size(newImg)
+	if size.Width != options.Width || size.Height != options.Height {
+		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+	}
+
+	Write("testdata/test_focal_point_out.jpg", newImg)
+}
+
+func TestFocalPointCropTopLeft(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	// A top left focal point is not mistaken for an unset one
+	point := &FocalPoint{X: 0, Y: 0}
+	newImg, err := Resize(buf, Options{Width: 300, Height: 300, Crop: true, FocalPoint: point})
+	if err != nil {
+		t.Fatalf("Cannot crop the image: %s", err)
+	}
+
+	expected, err := Resize(buf, Options{Width: 300, Height: 300, Crop: true, Gravity: GravityNorthWest})
+	if err != nil {
+		t.Fatalf("Cannot crop the image: %s", err)
+	}
+
+	centred, err := Resize(buf, Options{Width: 300, Height: 300, Crop: true})
+	if err != nil {
+		t.Fatalf("Cannot crop the image: %s", err)
+	}
+
+	if !bytes.Equal(newImg, expected) || bytes.Equal(newImg, centred) {
+		t.Error("Expected the crop to keep the top left corner")
+	}
+	if *point != (FocalPoint{X: 0, Y: 0}) {
+		t.Errorf("Expected the focal point of the caller to be left unchanged, got %#v", *point)
+	}
+}
+
+func TestCalculateFocalPointCrop(t *testing.T) {
+	tests := []struct {
+		point FocalPoint
+		left  int
+		top   int
+	}{
+		{FocalPoint{X: 0.5, Y: 0.5}, 350, 250},
+		{FocalPoint{X: 0.25, Y: 0.75}, 100, 450},
+		{FocalPoint{X: 0.01, Y: 0.99}, 0, 500},
+		{FocalPoint{X: 2, Y: -1}, 700, 0},
+	}
+
+	for _, test := range tests {
+		left, top := calculateFocalPointCrop(1000, 800, 300, 300, test.point)
+		if left != test.left || top != test.top {
+			t.Errorf("Invalid crop for %#v: %d,%d, expected %d,%d", test.point, left, top, test.left, test.top)
+		}
+	}
+}
+
+func TestRotateFocalPoint(t *testing.T) {
+	tests := []struct {
+		angle    Angle
+		flip     bool
+		flop     bool
+		expected FocalPoint
+	}{
+		{D0, false, false, FocalPoint{X: 0.2, Y: 0.1}},
+		{D90, false, false, FocalPoint{X: 0.9, Y: 0.2}},
+		{D180, false, false, FocalPoint{X: 0.8, Y: 0.9}},
+		{D270, false, false, FocalPoint{X: 0.1, Y: 0.8}},
+		{D0, true, false, FocalPoint{X: 0.8, Y: 0.1}},
+		{D0, false, true, FocalPoint{X: 0.2, Y: 0.9}},
+		{D90, true, false, FocalPoint{X: 0.1, Y: 0.2}},
+	}
+
+	for _, test := range tests {
+		p := rotateFocalPoint(FocalPoint{X: 0.2, Y: 0.1}, test.angle, test.flip, test.flop)
+		if math.Abs(p.X-test.expected.X) > 1e-9 || math.Abs(p.Y-test.expected.Y) > 1e-9 {
+			t.Errorf("Invalid focal point for %v (flip: %v, flop: %v): %#v, expected %#v", test.angle, test.flip, test.flop, p, test.expected)
+		}
+	}
+}
+
+func TestCalculateCropCorners(t *testing.T) {
+	tests := []struct {
+		gravity Gravity
+		left    int
+		top     int
+	}{
+		{GravityNorthEast, 700, 0},
+		{GravityNorthWest, 0, 0},
+		{GravitySouthEast, 700, 500},
+		{GravitySouthWest, 0, 500},
+	}
+
+	for _, test := range tests {
+		left, top := calculateCrop(1000, 800, 300, 300, test.gravity)
+		if left != test.left || top != test.top {
+			t.Errorf("Invalid crop for gravity %d: %d,%d, expected %d,%d", test.gravity, left, top, test.left, test.top)
+		}
+	}
+}
+
+func TestCalculateEmbed(t *testing.T) {
+	tests := []struct {
+		gravity Gravity
+		left    int
+		top     int
+	}{
+		{GravityCentre, 50, 100},
+		{GravityNorth, 50, 0},
+		{GravityEast, 100, 100},
+		{GravitySouth, 50, 200},
+		{GravityWest, 0, 100},
+		{GravityNorthEast, 100, 0},
+		{GravityNorthWest, 0, 0},
+		{GravitySouthEast, 100, 200},
+		{GravitySouthWest, 0, 200},
+	}
+
+	for _, test := range tests {
+		left, top := calculateEmbed(300, 200, 400, 400, test.gravity)
+		if left != test.left || top != test.top {
+			t.Errorf("Invalid embed for gravity %d: %d,%d, expected %d,%d", test.gravity, left, top, test.left, test.top)
+		}
+	}
+}
+
+func TestEmbedWithGravity(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	for _, gravity := range []Gravity{GravityNorthWest, GravitySouthEast} {
+		options := Options{Width: 400, Height: 600, Embed: true, Gravity: gravity, Extend: ExtendWhite}
+		newImg, err := Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		size, _ := Size(newImg)
+		if size.Width != options.Width || size.Height != options.Height {
+			t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+		}
+	}
+}
+
+func TestRotateArbitraryAngle(t *testing.T) {
+	buf, _ := Read("testdata/test.png")
+	size, _ := Size(buf)
+
+	tests := []Options{
+		{Rotation: Rotation{Angle: 5}},
+		{Rotation: Rotation{Angle: -12.5, Transparent: true}, Type: PNG},
+		{Rotation: Rotation{Angle: 30, Crop: true}},
+		{Rotate: D45, Background: Color{255, 255, 255}},
+	}
+
+	for _, options := range tests {
+		newImg, err := Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		newSize, _ := Size(newImg)
+		if options.Rotation.Crop {
+			if newSize.Width >= size.Width || newSize.Height >= size.Height {
+				t.Errorf("Invalid cropped image size: %dx%d", newSize.Width, newSize.Height)
+			}
+		} else if newSize.Width <= size.Width || newSize.Height <= size.Height {
+			t.Errorf("Invalid rotated image size: %dx%d", newSize.Width, newSize.Height)
+		}
+
+		if options.Rotation.Transparent {
+			metadata, _ := Metadata(newImg)
+			if !metadata.Alpha {
+				t.Error("Rotated image has no alpha channel")
+			}
+		}
+	}
+}
+
+func TestCalculateArbitraryAngle(t *testing.T) {
+	tests := []struct {
+		options  Options
+		expected float64
+	}{
+		{Options{Rotate: D90}, 0},
+		{Options{Rotate: D135}, 45},
+		{Options{Rotate: 111}, 21},
+		{Options{Rotation: Rotation{Angle: 2.5}}, 2.5},
+		{Options{Rotation: Rotation{Angle: 365}}, 5},
+		{Options{Rotate: D90, Rotation: Rotation{Angle: -1.5}}, -1.5},
+	}
+
+	for _, test := range tests {
+		angle := calculateArbitraryAngle(test.options)
+		if math.Abs(angle-test.expected) > 1e-9 {
+			t.Errorf("Invalid angle for %#v: %f, expected %f", test.options, angle, test.expected)
+		}
+	}
+}
+
+func TestCalculateInscribedRect(t *testing.T) {
+	tests := []struct {
+		width, height, angle float64
+		expWidth, expHeight  float64
+	}{
+		{400, 300, 0, 400, 300},
+		{400, 300, 90, 300, 400},
+		{100, 100, 45, 70.710678, 70.710678},
+		{1000, 100, 30, 100, 57.735027},
+		{400, 300, 10, 363.766449, 240.486144},
+	}
+
+	for _, test := range tests {
+		width, height := calculateInscribedRect(test.width, test.height, test.angle)
+		if math.Abs(width-test.expWidth) > 1e-5 || math.Abs(height-test.expHeight) > 1e-5 {
+			t.Errorf("Invalid inscribed rect for %vx%v at %v degrees: %fx%f", test.width, test.height, test.angle, width, height)
+		}
+	}
+}
+
+func TestPaddingAndBorder(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	tests := []struct {
+		options       Options
+		width, height int
+	}{
+		{Options{Width: 400, Height: 300, Padding: Padding{Top: 10, Right: 20, Bottom: 30, Left: 40}}, 460, 340},
+		{Options{Width: 400, Height: 300, Padding: Padding{Top: 10, Left: 5, Percent: true}, Extend: ExtendWhite}, 420, 330},
+		{Options{Width: 400, Height: 300, Border: Border{Width: 15, Color: Color{200, 180, 150}}}, 430, 330},
+		{Options{Width: 400, Height: 300, Padding: Padding{Top: 5, Right: 5, Bottom: 5, Left: 5}, Border: Border{Width: 5}}, 420, 320},
+	}
+
+	for _, test := range tests {
+		newImg, err := Resize(buf, test.options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", test.options, err)
+		}
+
+		size, _ := Size(newImg)
+		if size.Width != test.width || size.Height != test.height {
+			t.Errorf("Invalid image size: %dx%d, expected %dx%d", size.Width, size.Height, test.width, test.height)
+		}
+	}
+}
+
+func TestCalculatePadding(t *testing.T) {
+	top, right, bottom, left := calculatePadding(Padding{Top: 10, Right: 5, Bottom: 0, Left: -5, Percent: true}, 300, 200)
+	if top != 20 || right != 15 || bottom != 0 || left != 0 {
+		t.Errorf("Invalid padding: %d %d %d %d", top, right, bottom, left)
+	}
+}
+
+func TestMaskImage(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+	mask, _ := Read("testdata/transparent.png")
+
+	tests := []Options{
+		{Width: 300, Height: 300, Crop: true, Type: PNG, Mask: Mask{Shape: MaskCircle}},
+		{Width: 400, Height: 300, Type: PNG, Mask: Mask{Shape: MaskEllipse}},
+		{Width: 400, Height: 300, Type: WEBP, CornerRadius: 40},
+		{Width: 400, Height: 300, Type: PNG, Mask: Mask{Buf: mask}},
+	}
+
+	for _, options := range tests {
+		newImg, err := Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		metadata, _ := Metadata(newImg)
+		if !metadata.Alpha {
+			t.Errorf("Masked image has no alpha channel: %#v", options)
+		}
+		if metadata.Size.Width != options.Width || metadata.Size.Height != options.Height {
+			t.Errorf("Invalid image size: %dx%d", metadata.Size.Width, metadata.Size.Height)
+		}
+	}
+}
+
+func TestMaskImageFlattenJpeg(t *testing.T) {
+	buf, _ := Read("testdata/transparent.png")
+	options := Options{Width: 300, Height: 300, Crop: true, Type: JPEG, CornerRadius: 50, Background: Color{255, 255, 255}}
+
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	if DetermineImageType(newImg) != JPEG {
+		t.Fatal("Image is not jpeg")
+	}
+
+	Write("testdata/test_rounded_corners_out.jpg", newImg)
+}
+
+func TestMaskShapes(t *testing.T) {
+	maskAlpha := func(shape MaskShape, width, height, radius int) []uint8 {
+		buf := solidImage(t, width, height, color.NRGBA{255, 255, 255, 255})
+		newImg, err := Resize(buf, Options{Width: width, Height: height, Type: PNG, Mask: Mask{Shape: shape}, CornerRadius: radius})
+		if err != nil {
+			t.Fatalf("Resize(imgData, mask %d) error: %#v", shape, err)
+		}
+
+		img, err := png.Decode(bytes.NewReader(newImg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		alpha := make([]uint8, 0, width*height)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				alpha = append(alpha, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).A)
+			}
+		}
+		return alpha
+	}
+
+	circle := maskAlpha(MaskCircle, 10, 10, 0)
+	if circle[4*10+4] != 255 || circle[0] != 0 || circle[9*10+9] != 0 {
+		t.Errorf("Invalid circle mask: %v", circle)
+	}
+
+	ellipse := maskAlpha(MaskEllipse, 20, 10, 0)
+	if ellipse[5*20+10] != 255 || ellipse[5*20] == 255 || ellipse[0] != 0 {
+		t.Errorf("Invalid ellipse mask: %v", ellipse)
+	}
+
+	square := maskAlpha(MaskRoundedRect, 10, 10, 0)
+	for i, value := range square {
+		if value != 255 {
+			t.Fatalf("Invalid square mask value at %d: %d", i, value)
+		}
+	}
+
+	rounded := maskAlpha(MaskRoundedRect, 10, 10, 5)
+	for i := range rounded {
+		if absDiff(rounded[i], circle[i]) > 1 {
+			t.Fatalf("Expected full radius rounded mask to be a circle at %d: %d != %d", i, rounded[i], circle[i])
+		}
+	}
+}
+
+func TestResizeKernels(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	for _, kernel := range []Kernel{KernelLanczos3, KernelLanczos2, KernelMitchell, KernelCubic, KernelLinear, KernelNearest} {
+		if kernel == KernelMitchell && !(VipsMajorVersion >= 8 && VipsMinorVersion >= 10) {
+			continue
+		}
+
+		for _, options := range []Options{{Width: 300, Height: 200, Kernel: kernel}, {Width: 2000, Enlarge: true, Kernel: kernel}} {
+			newImg, err := Resize(buf, options)
+			if err != nil {
+				t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+			}
+
+			size, _ := Size(newImg)
+			if size.Width != options.Width || (options.Height > 0 && size.Height != options.Height) {
+				t.Errorf("Invalid image size for kernel %s: %dx%d", kernel, size.Width, size.Height)
+			}
+		}
+	}
+}
+
+func TestResizePremultipliedAlpha(t *testing.T) {
+	// White opaque stripes over fully transparent black pixels
+	img := image.NewNRGBA(image.Rect(0, 0, 400, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 400; x++ {
+			if (x/10)%2 == 0 {
+				img.Set(x, y, color.NRGBA{255, 255, 255, 255})
+			}
+		}
+	}
+	input := &bytes.Buffer{}
+	png.Encode(input, img)
+
+	for _, linear := range []bool{false, true} {
+		options := Options{Width: 97, Height: 97, LinearLight: linear}
+		newImg, err := Resize(input.Bytes(), options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		out, err := png.Decode(bytes.NewReader(newImg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bounds := out.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(out.At(x, y)).(color.NRGBA)
+				if c.A > 16 && c.R < 240 {
+					t.Fatalf("Dark fringe at %d,%d: %#v (linear light: %v)", x, y, c, linear)
+				}
+			}
+		}
+	}
+}
+
+func TestResizeLinearLight(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+	options := Options{Width: 300, Height: 200, LinearLight: true}
+
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	metadata, _ := Metadata(newImg)
+	if metadata.Size.Width != options.Width || metadata.Size.Height != options.Height {
+		t.Errorf("Invalid image size: %dx%d", metadata.Size.Width, metadata.Size.Height)
+	}
+	if metadata.Space != "srgb" {
+		t.Errorf("Invalid colour space: %s", metadata.Space)
+	}
+}
+
+func TestResizeScaleDPRMaxPixels(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	tests := []struct {
+		options       Options
+		width, height int
+	}{
+		{Options{Scale: 50}, 840, 525},
+		{Options{Width: 300, Height: 200, DPR: 2}, 600, 400},
+		{Options{Width: 300, Height: 200, DPR: 10}, 1575, 1050},
+	}
+
+	for _, test := range tests {
+		newImg, err := Resize(buf, test.options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", test.options, err)
+		}
+
+		newSize, _ := Size(newImg)
+		if newSize.Width != test.width || newSize.Height != test.height {
+			t.Errorf("Invalid image size for %#v: %dx%d, expected %dx%d", test.options, newSize.Width, newSize.Height, test.width, test.height)
+		}
+	}
+
+	newImg, err := Resize(buf, Options{MaxPixels: 100000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSize, _ := Size(newImg)
+	if newSize.Width*newSize.Height > 100000 || newSize.Width*newSize.Height < 95000 {
+		t.Errorf("Invalid image size for pixels budget: %dx%d", newSize.Width, newSize.Height)
+	}
+}
+
+func TestImageCalculationsScaleDPRMaxPixels(t *testing.T) {
+	tests := []struct {
+		options       Options
+		width, height int
+	}{
+		{Options{Scale: 25}, 250, 200},
+		{Options{Scale: 150}, 1500, 1200},
+		{Options{Scale: 50, Width: 100}, 100, 80},
+		{Options{Width: 200, DPR: 2}, 400, 320},
+		{Options{Width: 600, Height: 600, DPR: 3}, 800, 800},
+		{Options{Width: 600, Height: 600, DPR: 3, Enlarge: true}, 1800, 1800},
+		{Options{Width: 2000, DPR: 2}, 2000, 1600},
+		{Options{Width: 400, DPR: 0.5}, 200, 160},
+		{Options{MaxPixels: 20000}, 158, 126},
+		{Options{Width: 500, Height: 500, Crop: true, MaxPixels: 10000}, 100, 100},
+	}
+
+	for _, test := range tests {
+		o := test.options
+		imageCalculations(&o, 1000, 800)
+		if o.Width != test.width || o.Height != test.height {
+			t.Errorf("Invalid size for %#v: %dx%d, expected %dx%d", test.options, o.Width, o.Height, test.width, test.height)
+		}
+	}
+}
+
+func TestResizeAspectRatio(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	tests := []struct {
+		options       Options
+		width, height int
+	}{
+		{Options{AspectRatio: 16.0 / 9}, 1680, 945},
+		{Options{AspectRatio: 1, Gravity: GravitySmart}, 1050, 1050},
+		{Options{AspectRatio: 4.0 / 5, Width: 400}, 400, 500},
+		{Options{AspectRatio: 1, Height: 300, Gravity: GravityNorthWest}, 300, 300},
+		{Options{AspectRatio: 2, Scale: 50}, 840, 420},
+	}
+
+	for _, test := range tests {
+		newImg, err := Resize(buf, test.options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", test.options, err)
+		}
+
+		size, _ := Size(newImg)
+		if size.Width != test.width || size.Height != test.height {
+			t.Errorf("Invalid image size for %#v: %dx%d, expected %dx%d", test.options, size.Width, size.Height, test.width, test.height)
+		}
+	}
+}
+
+func TestCalculateAspectRatioCrop(t *testing.T) {
+	tests := []struct {
+		options       Options
+		width, height int
+	}{
+		{Options{AspectRatio: 16.0 / 9}, 1000, 563},
+		{Options{AspectRatio: 1}, 800, 800},
+		{Options{AspectRatio: 0.5}, 400, 800},
+		{Options{AspectRatio: 4.0 / 5, Width: 200}, 200, 250},
+		{Options{AspectRatio: 3, Height: 100}, 300, 100},
+		{Options{AspectRatio: 1, Scale: 25}, 200, 200},
+	}
+
+	for _, test := range tests {
+		o := test.options
+		calculateAspectRatioCrop(&o, 1000, 800)
+		if o.Width != test.width || o.Height != test.height || !o.Crop {
+			t.Errorf("Invalid crop for %#v: %dx%d (crop: %t), expected %dx%d", test.options, o.Width, o.Height, o.Crop, test.width, test.height)
+		}
+	}
+}
+
+func TestParseAspectRatio(t *testing.T) {
+	tests := []struct {
+		ratio    string
+		expected float64
+		valid    bool
+	}{
+		{"16:9", 16.0 / 9, true},
+		{" 4 : 5 ", 0.8, true},
+		{"1.5", 1.5, true},
+		{"1", 1, true},
+		{"16:0", 0, false},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"a:b", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		ratio, err := ParseAspectRatio(test.ratio)
+		if (err == nil) != test.valid {
+			t.Errorf("Unexpected error for %q: %v", test.ratio, err)
+		}
+		if math.Abs(ratio-test.expected) > 1e-9 {
+			t.Errorf("Invalid aspect ratio for %q: %f, expected %f", test.ratio, ratio, test.expected)
+		}
+	}
+}
+
+func TestResizeMany(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	opts := append(SrcsetOptions(Options{}, 320, 640), SrcsetOptions(Options{Type: WEBP}, 320, 640)...)
+	opts = append(opts, Options{Width: 200, Height: 200, Crop: true, Gravity: GravitySmart}, Options{AreaWidth: 100, AreaHeight: 50, Top: 10, Left: 10})
+	// Outputs sized relatively to the input, along with a small output
+	opts = append(opts, Options{Scale: 50}, Options{AspectRatio: 16.0 / 9, Scale: 50}, Options{Width: 100})
+
+	images, err := ResizeMany(buf, opts)
+	if err != nil {
+		t.Fatalf("Cannot resize the image: %s", err)
+	}
+	if len(images) != len(opts) {
+		t.Fatalf("Invalid images length: %d", len(images))
+	}
+
+	for i, image := range images {
+		expected, err := Resize(buf, opts[i])
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", opts[i], err)
+		}
+		size, _ := Size(expected)
+
+		if DetermineImageType(image.Buffer) != image.Type {
+			t.Errorf("Invalid image type for %#v: %s", opts[i], ImageTypeName(image.Type))
+		}
+		if image.Width != size.Width || image.Height != size.Height {
+			t.Errorf("Invalid image size for %#v: %dx%d, expected %dx%d", opts[i], image.Width, image.Height, size.Width, size.Height)
+		}
+
+		actual, _ := Size(image.Buffer)
+		if actual.Width != image.Width || actual.Height != image.Height {
+			t.Errorf("Invalid buffer size for %#v: %dx%d", opts[i], actual.Width, actual.Height)
+		}
+	}
+
+	scaled := images[len(images)-3]
+	if scaled.Width != 840 || scaled.Height != 525 {
+		t.Errorf("Invalid scaled image size: %dx%d, expected 840x525", scaled.Width, scaled.Height)
+	}
+
+	if images[0].Type != JPEG || images[2].Type != WEBP {
+		t.Errorf("Invalid image types: %s, %s", ImageTypeName(images[0].Type), ImageTypeName(images[2].Type))
+	}
+}
+
+func TestCalculateSharedShrink(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	tests := []struct {
+		opts   []Options
+		shrink int
+	}{
+		{[]Options{{Width: 200}, {Width: 400}}, 3},
+		{[]Options{{Width: 200}, {Width: 100}}, 6},
+		{[]Options{{Width: 200}, {Width: 800}}, 1},
+		{[]Options{{Width: 200}, {}}, 1},
+		{[]Options{{Width: 200}, {Width: 100, Rotation: Rotation{Angle: 10}}}, 1},
+		{[]Options{{Width: 200}, {Width: 100, AreaWidth: 50, AreaHeight: 50}}, 1},
+		{[]Options{{Width: 100}, {Scale: 50}}, 1},
+		{[]Options{{Width: 100}, {AspectRatio: 16.0 / 9, Scale: 50}}, 1},
+		{[]Options{{Width: 100}, {AspectRatio: 16.0 / 9, Width: 320}}, 3},
+		{nil, 1},
+	}
+
+	for _, test := range tests {
+		image, imageType, err := vipsRead(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		shrink := calculateSharedShrink(image, imageType, test.opts)
+		if shrink != test.shrink {
+			t.Errorf("Invalid shrink for %#v: %d, expected %d", test.opts, shrink, test.shrink)
+		}
+	}
+}
+
+func TestResizeThumbnail(t *testing.T) {
+	if !(VipsMajorVersion >= 8 && VipsMinorVersion >= 7) {
+		t.Skipf("Skipping this test, libvips doesn't meet version requirement %s >= 8.7", VipsVersion)
+	}
+
+	files := []string{"test.jpg", "test.png", "test.webp", "test.heic", "vertical.jpg"}
+	tests := []Options{
+		{Width: 300},
+		{Height: 200},
+		{Width: 300, Height: 200},
+		{Width: 300, Height: 300, Crop: true},
+		{Width: 300, Height: 300, Crop: true, Gravity: GravitySmart},
+		{Width: 3000, Height: 2000, Enlarge: true},
+		{AspectRatio: 16.0 / 9, Width: 320},
+	}
+
+	for _, file := range files {
+		buf, _ := Read(path.Join("testdata", file))
+		if !IsTypeSupported(DetermineImageType(buf)) {
+			continue
+		}
+
+		for _, test := range tests {
+			test.Type = JPEG
+
+			image, _, err := loadImage(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := calculateThumbnail(image, test); !ok {
+				t.Errorf("Expected the thumbnail path for %s with %#v", file, test)
+			}
+
+			newImg, err := Resize(buf, test)
+			if err != nil {
+				t.Fatalf("Resize(%s, %#v) error: %#v", file, test, err)
+			}
+			expected, err := runPipeline(buf, test)
+			if err != nil {
+				t.Fatalf("Cannot process %s with %#v: %#v", file, test, err)
+			}
+
+			size, _ := Size(newImg)
+			expectedSize, _ := Size(expected)
+			if size != expectedSize {
+				t.Errorf("Invalid thumbnail size for %s with %#v: %dx%d, expected %dx%d", file, test, size.Width, size.Height, expectedSize.Width, expectedSize.Height)
+			}
+		}
+	}
+}
+
+func TestResizeThumbnailMatchesPipeline(t *testing.T) {
+	if !(VipsMajorVersion >= 8 && VipsMinorVersion >= 7) {
+		t.Skipf("Skipping this test, libvips doesn't meet version requirement %s >= 8.7", VipsVersion)
+	}
+
+	// Colour management and auto rotation are where the thumbnail operation
+	// could differ from the pipeline: an ICC tagged image, and EXIF rotations
+	files := []string{"test_icc_prophoto.jpg", "exif/Landscape_3.jpg", "exif/Landscape_6.jpg", "exif/Portrait_8.jpg"}
+	tests := []Options{
+		{Width: 300, Type: JPEG},
+		{Width: 200, Height: 200, Crop: true, Type: JPEG},
+	}
+
+	for _, file := range files {
+		buf, _ := Read(path.Join("testdata", file))
+
+		for _, test := range tests {
+			image, _, err := loadImage(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := calculateThumbnail(image, test); !ok {
+				t.Fatalf("Expected the thumbnail path for %s with %#v", file, test)
+			}
+
+			newImg, err := Resize(buf, test)
+			if err != nil {
+				t.Fatalf("Resize(%s, %#v) error: %#v", file, test, err)
+			}
+			expected, err := runPipeline(buf, test)
+			if err != nil {
+				t.Fatalf("Cannot process %s with %#v: %#v", file, test, err)
+			}
+
+			size, _ := Size(newImg)
+			expectedSize, _ := Size(expected)
+			if size != expectedSize {
+				t.Errorf("Invalid thumbnail size for %s with %#v: %dx%d, expected %dx%d", file, test, size.Width, size.Height, expectedSize.Width, expectedSize.Height)
+				continue
+			}
+
+			// Both outputs only differ by their resampling
+			diff, samples := 0, 0
+			for y := 0; y < 10; y++ {
+				for x := 0; x < 10; x++ {
+					px, py := (2*x+1)*size.Width/20, (2*y+1)*size.Height/20
+					c, e := decodePixel(t, newImg, px, py), decodePixel(t, expected, px, py)
+					diff += int(absDiff(c.R, e.R)) + int(absDiff(c.G, e.G)) + int(absDiff(c.B, e.B))
+					samples += 3
+				}
+			}
+			if mean := diff / samples; mean > 8 {
+				t.Errorf("Thumbnail of %s with %#v differs from the pipeline output by %d on average", file, test, mean)
+			}
+		}
+	}
+}
+
+func TestCalculateThumbnail(t *testing.T) {
+	if !(VipsMajorVersion >= 8 && VipsMinorVersion >= 7) {
+		t.Skipf("Skipping this test, libvips doesn't meet version requirement %s >= 8.7", VipsVersion)
+	}
+
+	buf, _ := Read("testdata/test.jpg")
+
+	tests := []struct {
+		options Options
+		ok      bool
+	}{
+		{Options{Width: 300, Height: 200}, true},
+		{Options{Width: 300, Height: 200, Crop: true, Gravity: GravitySmart}, true},
+		{Options{Width: 300, Height: 200, Quality: 60, Type: WEBP, Gamma: 2.2}, true},
+		{Options{}, false},
+		{Options{Type: PNG}, false},
+		{Options{Width: 300, Height: 200, Embed: true}, false},
+		{Options{Width: 300, Height: 200, Crop: true, Gravity: GravityNorth}, false},
+		{Options{Width: 300, Height: 200, Crop: true, FocalPoint: &FocalPoint{X: 0.2, Y: 0.3}}, false},
+		{Options{Width: 3000, Height: 2000, Crop: true}, false},
+		{Options{Width: 300, Height: 200, Rotate: 90}, false},
+		{Options{Width: 300, Height: 200, Flip: true}, false},
+		{Options{Width: 300, Height: 200, Trim: true}, false},
+		{Options{Width: 300, Height: 200, Kernel: KernelNearest}, false},
+		{Options{Width: 300, Height: 200, Interpolator: Nohalo}, false},
+		{Options{Width: 300, Height: 200, CornerRadius: 10}, false},
+		{Options{Width: 300, Height: 200, GaussianBlur: GaussianBlur{Sigma: 2}}, false},
+		{Options{Width: 300, Height: 200, Watermark: Watermark{Text: "bimg"}}, false},
+		{Options{Width: 300, Height: 200, AreaWidth: 100, AreaHeight: 100}, false},
+	}
+
+	for _, test := range tests {
+		image, _, err := loadImage(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		o, ok := calculateThumbnail(image, test.options)
+		if ok != test.ok {
+			t.Errorf("Invalid thumbnail path for %#v: %t, expected %t", test.options, ok, test.ok)
+		}
+		if ok && (o.Width != test.options.Width || o.Height != test.options.Height) {
+			t.Errorf("Invalid thumbnail size for %#v: %dx%d", test.options, o.Width, o.Height)
+		}
+	}
+}
+
+func TestShrinkOnLoad(t *testing.T) {
+	files := []string{"test.jpg", "test.webp", "test.pdf", "test.svg", "test.heic"}
+
+	for _, file := range files {
+		buf, _ := Read(path.Join("testdata", file))
+		imageType := DetermineImageType(buf)
+		if !IsTypeSupported(imageType) || !supportsShrinkOnLoad(imageType) {
+			continue
+		}
+
+		image, _, err := loadImage(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inWidth := int(image.Xsize)
+
+		image, factor, err := shrinkOnLoad(buf, image, imageType, 4, 4)
+		if err != nil {
+			t.Fatalf("Cannot shrink %s on load: %s", file, err)
+		}
+
+		if int(image.Xsize) > inWidth || factor < 1 || factor > 4 {
+			t.Errorf("Invalid shrink on load for %s: width %d, factor %f", file, image.Xsize, factor)
+		}
+		if imageType != WEBP && math.Abs(float64(image.Xsize)*4/factor-float64(inWidth)) > 4 {
+			t.Errorf("Invalid residual factor for %s: width %d, factor %f", file, image.Xsize, factor)
+		}
+	}
+}
+
+func TestShrinkOnLoadTiff(t *testing.T) {
+	if !IsTypeSupportedSave(TIFF) {
+		t.Skip("Skipping this test, libvips doesn't support TIFF")
+	}
+
+	saveTiff := func(buf []byte, pyramid, subifd bool, pageHeight int) []byte {
+		image, _, err := loadImage(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err = vipsSaveTiffLayout(image, pyramid, subifd, pageHeight)
+		if err != nil {
+			t.Fatalf("Cannot save the TIFF fixture: %s", err)
+		}
+		return buf
+	}
+	shrinkTiff := func(buf []byte, shrink int) (int, int, float64) {
+		image, _, err := loadImage(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inWidth := int(image.Xsize)
+
+		image, factor, err := shrinkOnLoad(buf, image, TIFF, float64(shrink), shrink)
+		if err != nil {
+			t.Fatalf("Cannot shrink the image on load: %s", err)
+		}
+		return inWidth, int(image.Xsize), factor
+	}
+
+	src, _ := Read("testdata/test.jpg")
+
+	// A flat TIFF has no pyramid level to load
+	flat, err := Resize(src, Options{Type: TIFF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inWidth, width, factor := shrinkTiff(flat, 4); width != inWidth || factor != 4 {
+		t.Errorf("Invalid flat TIFF shrink on load: width %d, factor %f", width, factor)
+	}
+
+	// The chosen level is the smallest one not reduced more than the shrink
+	layouts := []struct {
+		name   string
+		subifd bool
+	}{{"page pyramid", false}}
+	if VipsMajorVersion >= 8 && VipsMinorVersion >= 10 {
+		layouts = append(layouts, struct {
+			name   string
+			subifd bool
+		}{"subifd pyramid", true})
+	}
+
+	for _, layout := range layouts {
+		buf := saveTiff(src, true, layout.subifd, 0)
+
+		for level := 1; level <= 3; level++ {
+			for _, shrink := range []int{1 << level, 1<<level + 1} {
+				inWidth, width, factor := shrinkTiff(buf, shrink)
+				if absInt(width-inWidth>>level) > 1 {
+					t.Errorf("%s: expected level %d for shrink %d, got width %d of %d", layout.name, level, shrink, width, inWidth)
+				}
+				// The factor is what remains to shrink after the level
+				if expected := float64(shrink) * float64(width) / float64(inWidth); math.Abs(factor-expected) > 1e-9 {
+					t.Errorf("%s: invalid factor %f for shrink %d, expected %f", layout.name, factor, shrink, expected)
+				}
+			}
+		}
+	}
+
+	// The pages of a document are not pyramid levels: a red first page and a
+	// blue second page of the same size
+	doc := saveTiff(drawImage(t, 200, 200, func(x, y int) color.NRGBA {
+		if y < 100 {
+			return color.NRGBA{255, 0, 0, 255}
+		}
+		return color.NRGBA{0, 0, 255, 255}
+	}), false, false, 100)
+
+	if inWidth, width, factor := shrinkTiff(doc, 2); width != inWidth || factor != 2 {
+		t.Errorf("Invalid multi-page TIFF shrink on load: width %d, factor %f", width, factor)
+	}
+
+	newImg, err := Resize(doc, Options{Width: 50, Type: PNG})
+	if err != nil {
+		t.Fatalf("Cannot resize the multi-page TIFF: %s", err)
+	}
+	if err := assertSize(newImg, 50, 25); err != nil {
+		t.Error(err)
+	}
+	if c := decodePixel(t, newImg, 25, 12); c.R < 200 || c.B > 50 {
+		t.Errorf("Expected the first page to be loaded, got color %v", c)
+	}
+}
+
+func TestResizeShrinkOnLoad(t *testing.T) {
+	files := []string{"test.pdf", "test.svg", "test.heic"}
+
+	for _, file := range files {
+		buf, _ := Read(path.Join("testdata", file))
+		if !IsTypeSupported(DetermineImageType(buf)) {
+			continue
+		}
+
+		newImg, err := Resize(buf, Options{Width: 100, Height: 100, Embed: true, Type: JPEG})
+		if err != nil {
+			t.Fatalf("Resize(%s) error: %#v", file, err)
+		}
+
+		if err := assertSize(newImg, 100, 100); err != nil {
+			t.Errorf("Invalid size for %s: %s", file, err)
+		}
+	}
+}
+
+func TestWatermarkImageScaleAndGravity(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+	red := solidImage(t, 100, 100, color.NRGBA{R: 255, A: 255})
+
+	isRed := func(img image.Image, x, y int) bool {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return r>>8 > 230 && g>>8 < 25 && b>>8 < 25
+	}
+
+	tests := []struct {
+		watermark WatermarkImage
+		red       [][2]int
+		notRed    [][2]int
+	}{
+		{
+			WatermarkImage{Buf: red, Scale: 10, UseGravity: true, Gravity: GravitySouthEast, Margin: 10},
+			[][2]int{{715, 515}, {785, 585}},
+			[][2]int{{795, 595}, {705, 505}},
+		},
+		{
+			WatermarkImage{Buf: red, UseGravity: true, Gravity: GravityCentre},
+			[][2]int{{355, 255}, {445, 345}},
+			[][2]int{{345, 245}, {455, 355}},
+		},
+		{
+			WatermarkImage{Buf: red, Replicate: true, Margin: 100},
+			[][2]int{{50, 50}, {250, 50}, {650, 450}},
+			[][2]int{{150, 150}, {150, 50}, {50, 150}},
+		},
+	}
+
+	for _, test := range tests {
+		newImg, err := Resize(buf, Options{Width: 800, Height: 600, Crop: true, WatermarkImage: test.watermark})
+		if err != nil {
+			t.Fatalf("Cannot watermark the image: %s", err)
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(newImg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, p := range test.red {
+			if !isRed(img, p[0], p[1]) {
+				t.Errorf("Expected the watermark at %v with %#v", p, test.watermark)
+			}
+		}
+		for _, p := range test.notRed {
+			if isRed(img, p[0], p[1]) {
+				t.Errorf("Unexpected watermark at %v with %#v", p, test.watermark)
+			}
+		}
+	}
+}
+
+func TestCalculateWatermarkPosition(t *testing.T) {
+	tests := []struct {
+		gravity   Gravity
+		margin    int
+		left, top int
+	}{
+		{GravityCentre, 0, 450, 350},
+		{GravityCentre, 10, 450, 350},
+		{GravityNorthWest, 10, 10, 10},
+		{GravitySouthEast, 10, 890, 690},
+		{GravityNorth, 20, 450, 20},
+		{GravityEast, 20, 880, 350},
+		{GravitySouthWest, 0, 0, 700},
+	}
+
+	for _, test := range tests {
+		left, top := calculateWatermarkPosition(1000, 800, 100, 100, test.gravity, test.margin)
+		if left != test.left || top != test.top {
+			t.Errorf("Invalid position for gravity %d and margin %d: %d, %d, expected %d, %d", test.gravity, test.margin, left, top, test.left, test.top)
+		}
+	}
+}
+
+func TestResizeModulateAndLinear(t *testing.T) {
+	cases := []struct {
+		name    string
+		color   color.NRGBA
+		options Options
+		check   func(c color.NRGBA) bool
+	}{
+		{"reduce saturation", color.NRGBA{200, 50, 50, 128}, Options{Modulate: Modulate{Saturation: -0.9}},
+			func(c color.NRGBA) bool { return c.R > c.G && c.R-c.G < 60 && absDiff(c.G, c.B) < 4 }},
+		{"hue", color.NRGBA{200, 50, 50, 128}, Options{Modulate: Modulate{Hue: 180}},
+			func(c color.NRGBA) bool { return c.G > c.R && c.B > c.R }},
+		{"brighten", color.NRGBA{100, 100, 100, 128}, Options{Modulate: Modulate{Brightness: 0.5}},
+			func(c color.NRGBA) bool { return c.R > 130 && absDiff(c.R, c.B) < 4 }},
+		{"linear brightness", color.NRGBA{100, 100, 100, 128}, Options{Linear: Linear{Brightness: 0.2}},
+			func(c color.NRGBA) bool { return absDiff(c.R, 151) < 2 && absDiff(c.B, 151) < 2 }},
+		{"linear contrast", color.NRGBA{100, 100, 100, 128}, Options{Linear: Linear{Contrast: 1}},
+			func(c color.NRGBA) bool { return absDiff(c.R, 72) < 2 && absDiff(c.B, 72) < 2 }},
+		{"no contrast", color.NRGBA{100, 150, 200, 128}, Options{Linear: Linear{Contrast: -1}},
+			func(c color.NRGBA) bool { return absDiff(c.R, 128) < 2 && absDiff(c.B, 128) < 2 }},
+		{"unchanged", color.NRGBA{200, 50, 50, 128}, Options{Modulate: Modulate{Hue: 360}, Linear: Linear{Brightness: 0.0001}},
+			func(c color.NRGBA) bool { return absDiff(c.R, 200) < 3 && absDiff(c.G, 50) < 3 && absDiff(c.B, 50) < 3 }},
+	}
+
+	for _, tc := range cases {
+		newImg, err := Resize(solidImage(t, 40, 30, tc.color), tc.options)
+		if err != nil {
+			t.Errorf("%s: cannot adjust the image: %s", tc.name, err)
+			continue
+		}
+
+		c := decodePixel(t, newImg, 20, 15)
+		if !tc.check(c) {
+			t.Errorf("%s: unexpected color %v", tc.name, c)
+		}
+		// The alpha band is left untouched
+		if absDiff(c.A, 128) > 1 {
+			t.Errorf("%s: expected alpha 128, got %d", tc.name, c.A)
+		}
+	}
+}
+
+func TestResizeHistogramEffects(t *testing.T) {
+	// A washed out gradient, with every band in a narrow range
+	buf := drawImage(t, 256, 32, func(x, y int) color.NRGBA {
+		return color.NRGBA{uint8(100 + x/5), uint8(60 + x/8), uint8(180 + x/10), 200}
+	})
+
+	cases := []struct {
+		name    string
+		options Options
+		minSpan int
+	}{
+		{"normalize luminance", Options{Normalize: NormalizeLuminance}, 60},
+		{"normalize bands", Options{Normalize: NormalizeBands}, 200},
+		{"equalize", Options{Equalize: true}, 60},
+		{"clahe", Options{CLAHE: CLAHE{Width: 32, Height: 32, MaxSlope: 3}}, 0},
+	}
+
+	for _, tc := range cases {
+		newImg, err := Resize(buf, tc.options)
+		if err != nil {
+			t.Errorf("%s: cannot process the image: %s", tc.name, err)
+			continue
+		}
+
+		if err := assertSize(newImg, 256, 32); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+
+		first := decodePixel(t, newImg, 0, 16)
+		last := decodePixel(t, newImg, 255, 16)
+		for band, span := range []int{int(last.R) - int(first.R), int(last.G) - int(first.G), int(last.B) - int(first.B)} {
+			if tc.minSpan > 0 && span < tc.minSpan {
+				t.Errorf("%s: expected band %d to span at least %d, got %d", tc.name, band, tc.minSpan, span)
+			}
+		}
+		// The alpha band is left untouched
+		if first.A != 200 || last.A != 200 {
+			t.Errorf("%s: expected alpha 200, got %d and %d", tc.name, first.A, last.A)
+		}
+	}
+}
+
+func TestResizeColourFilters(t *testing.T) {
+	// Grayscale keeps the alpha band, with a single colour band
+	newImg, err := Resize(solidImage(t, 40, 30, color.NRGBA{200, 50, 50, 128}), Options{Filter: FilterGrayscale})
+	if err != nil {
+		t.Fatalf("Cannot convert the image to grayscale: %s", err)
+	}
+	meta, err := Metadata(newImg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Channels != 2 || !meta.Alpha {
+		t.Errorf("Expected a grayscale image with alpha, got %d channels", meta.Channels)
+	}
+	if c := decodePixel(t, newImg, 20, 15); c.R != c.G || c.G != c.B || absDiff(c.A, 128) > 1 {
+		t.Errorf("Unexpected grayscale color %v", c)
+	}
+
+	newImg, err = Resize(solidImage(t, 40, 30, color.NRGBA{128, 128, 128, 128}), Options{Filter: FilterSepia})
+	if err != nil {
+		t.Fatalf("Cannot apply the sepia filter: %s", err)
+	}
+	if c := decodePixel(t, newImg, 20, 15); c.R <= c.G || c.G <= c.B || absDiff(c.A, 128) > 1 {
+		t.Errorf("Unexpected sepia color %v", c)
+	}
+
+	// A neutral tint only scales the lightness, and keeps a neutral image neutral
+	newImg, err = Resize(solidImage(t, 40, 30, color.NRGBA{128, 128, 128, 255}), Options{Tint: Color{255, 255, 255}})
+	if err != nil {
+		t.Fatalf("Cannot tint the image: %s", err)
+	}
+	if c := decodePixel(t, newImg, 20, 15); absDiff(c.R, 128) > 2 || absDiff(c.G, c.R) > 1 || absDiff(c.B, c.R) > 1 {
+		t.Errorf("Unexpected white tint color %v", c)
+	}
+
+	newImg, err = Resize(solidImage(t, 40, 30, color.NRGBA{200, 40, 40, 255}), Options{Tint: Color{128, 128, 128}})
+	if err != nil {
+		t.Fatalf("Cannot tint the image: %s", err)
+	}
+	if c := decodePixel(t, newImg, 20, 15); absDiff(c.G, c.R) > 2 || absDiff(c.B, c.R) > 2 || c.R >= 128 {
+		t.Errorf("Unexpected grey tint color %v", c)
+	}
+
+	// The chroma of the image is multiplied by the one of the tint: red times
+	// blue keeps a positive a and turns b negative, giving a dark purple
+	newImg, err = Resize(solidImage(t, 40, 30, color.NRGBA{200, 40, 40, 255}), Options{Tint: Color{0, 0, 255}})
+	if err != nil {
+		t.Fatalf("Cannot tint the image: %s", err)
+	}
+	if c := decodePixel(t, newImg, 20, 15); c.R <= c.G || c.B <= c.G || c.R >= 200 {
+		t.Errorf("Unexpected blue tint color %v", c)
+	}
+
+	// Black maps to the shadows colour and white to the highlights colour
+	duotone := Duotone{Shadows: Color{20, 40, 120}, Highlights: Color{250, 200, 40}}
+	newImg, err = Resize(halvesImage(t, 40, 30), Options{Duotone: duotone})
+	if err != nil {
+		t.Fatalf("Cannot apply the duotone filter: %s", err)
+	}
+	for _, tc := range []struct {
+		x     int
+		color Color
+	}{{5, duotone.Shadows}, {35, duotone.Highlights}} {
+		c := decodePixel(t, newImg, tc.x, 15)
+		if absDiff(c.R, tc.color.R) > 3 || absDiff(c.G, tc.color.G) > 3 || absDiff(c.B, tc.color.B) > 3 {
+			t.Errorf("Unexpected duotone color %v at %d, expected %v", c, tc.x, tc.color)
+		}
+	}
+}
+
+func TestResizeConvolve(t *testing.T) {
+	src := solidImage(t, 40, 30, color.NRGBA{120, 60, 30, 128})
+
+	cases := []struct {
+		name    string
+		options Options
+	}{
+		{"identity", Options{Convolve: Convolve{Width: 3, Height: 3, Kernel: []float64{0, 0, 0, 0, 1, 0, 0, 0, 0}}}},
+		{"box blur", Options{Convolve: Convolve{Width: 3, Height: 3, Kernel: []float64{1, 1, 1, 1, 1, 1, 1, 1, 1}}}},
+		{"emboss", Options{Emboss: true}},
+	}
+
+	for _, tc := range cases {
+		newImg, err := Resize(src, tc.options)
+		if err != nil {
+			t.Errorf("%s: cannot convolve the image: %s", tc.name, err)
+			continue
+		}
+
+		// A flat image is left unchanged, since the kernels are scaled by their sum
+		c := decodePixel(t, newImg, 20, 15)
+		if absDiff(c.R, 120) > 1 || absDiff(c.G, 60) > 1 || absDiff(c.B, 30) > 1 || absDiff(c.A, 128) > 1 {
+			t.Errorf("%s: unexpected color %v", tc.name, c)
+		}
+	}
+
+	_, err := Resize(src, Options{Convolve: Convolve{Width: 3, Height: 3, Kernel: []float64{1, 1, 1}}})
+	if err == nil {
+		t.Error("Expected an error for a kernel not matching its size")
+	}
+}
+
+func TestResizeEdgeDetection(t *testing.T) {
+	// A vertical edge in the middle of the image
+	buf := halvesImage(t, 40, 30)
+
+	detections := []EdgeDetection{EdgeSobel, EdgeScharr}
+	if VipsMajorVersion >= 8 && VipsMinorVersion >= 8 {
+		detections = append(detections, EdgeCanny)
+	}
+
+	for _, detection := range detections {
+		newImg, err := Resize(buf, Options{EdgeDetection: detection})
+		if err != nil {
+			t.Errorf("Cannot detect the edges with %d: %s", detection, err)
+			continue
+		}
+
+		edge := uint8(0)
+		for x := 18; x < 22; x++ {
+			if c := decodePixel(t, newImg, x, 15); c.R > edge {
+				edge = c.R
+			}
+		}
+		if edge < 30 {
+			t.Errorf("Expected an edge with %d, got %d", detection, edge)
+		}
+		for _, x := range []int{5, 35} {
+			if c := decodePixel(t, newImg, x, 15); c.R > 10 {
+				t.Errorf("Expected no edge at %d with %d, got %d", x, detection, c.R)
+			}
+		}
+	}
+}
+
+func TestCalculateConvolveScale(t *testing.T) {
+	cases := []struct {
+		convolve Convolve
+		scale    float64
+	}{
+		{Convolve{Kernel: []float64{1, 1, 1, 1, 1, 1, 1, 1, 1}}, 9},
+		{Convolve{Kernel: []float64{-1, 0, 1, -2, 0, 2, -1, 0, 1}}, 1},
+		{Convolve{Kernel: []float64{1, 2, 1}, Scale: 2}, 2},
+		{embossKernel, 2},
+	}
+
+	for _, tc := range cases {
+		if scale := calculateConvolveScale(tc.convolve); scale != tc.scale {
+			t.Errorf("Invalid scale for %v: got %f, expected %f", tc.convolve.Kernel, scale, tc.scale)
+		}
+	}
+}
+
+func TestResizeRankAndMorphology(t *testing.T) {
+	// A 10x10 square from 15,10 to 24,19, with a single noisy pixel outside of it
+	square := func(inside, outside color.NRGBA) []byte {
+		return drawImage(t, 40, 30, func(x, y int) color.NRGBA {
+			if x >= 15 && x < 25 && y >= 10 && y < 20 || x == 5 && y == 5 {
+				return inside
+			}
+			return outside
+		})
+	}
+	white := color.NRGBA{255, 255, 255, 255}
+	black := color.NRGBA{0, 0, 0, 255}
+
+	cases := []struct {
+		name    string
+		buf     []byte
+		options Options
+		set     []image.Point
+		unset   []image.Point
+	}{
+		{"median", square(white, black), Options{Rank: Rank{Filter: RankMedian}},
+			[]image.Point{{20, 15}}, []image.Point{{5, 5}}},
+		{"max", square(white, black), Options{Rank: Rank{Filter: RankMax}},
+			[]image.Point{{14, 15}, {5, 5}, {6, 6}}, []image.Point{{13, 15}}},
+		{"min", square(white, black), Options{Rank: Rank{Filter: RankMin, Size: 5}},
+			[]image.Point{{20, 15}}, []image.Point{{16, 15}, {5, 5}}},
+		{"erode", square(white, black), Options{Morphology: Morphology{Operation: MorphErode}},
+			[]image.Point{{16, 15}}, []image.Point{{15, 15}, {5, 5}}},
+		{"dilate", square(white, black), Options{Morphology: Morphology{Operation: MorphDilate}},
+			[]image.Point{{14, 15}, {6, 6}}, []image.Point{{13, 15}}},
+		{"dilate cross", square(white, black), Options{Morphology: Morphology{Operation: MorphDilate, Width: 3, Height: 3,
+			Element: []bool{false, true, false, true, true, true, false, true, false}}},
+			[]image.Point{{14, 15}, {5, 6}}, []image.Point{{6, 6}}},
+		{"dilate alpha", square(color.NRGBA{0, 0, 0, 255}, color.NRGBA{0, 0, 0, 0}), Options{Morphology: Morphology{Operation: MorphDilate}},
+			[]image.Point{{14, 15}, {6, 6}}, []image.Point{{13, 15}}},
+	}
+
+	for _, tc := range cases {
+		newImg, err := Resize(tc.buf, tc.options)
+		if err != nil {
+			t.Errorf("%s: cannot process the image: %s", tc.name, err)
+			continue
+		}
+
+		// Pixels are set when white, or opaque for the alpha only image
+		isSet := func(p image.Point) bool {
+			c := decodePixel(t, newImg, p.X, p.Y)
+			if tc.name == "dilate alpha" {
+				return c.A > 128
+			}
+			return c.R > 128
+		}
+		for _, p := range tc.set {
+			if !isSet(p) {
+				t.Errorf("%s: expected %v to be set", tc.name, p)
+			}
+		}
+		for _, p := range tc.unset {
+			if isSet(p) {
+				t.Errorf("%s: expected %v to be unset", tc.name, p)
+			}
+		}
+	}
+
+	_, err := Resize(square(white, black), Options{Morphology: Morphology{Operation: MorphErode, Width: 3, Height: 3, Element: []bool{true}}})
+	if err == nil {
+		t.Error("Expected an error for an element not matching its size")
+	}
+}
+
+func TestCalculateRank(t *testing.T) {
+	cases := []struct {
+		rank        Rank
+		size, index int
+	}{
+		{Rank{Filter: RankMedian}, 3, 4},
+		{Rank{Filter: RankMedian, Size: 5}, 5, 12},
+		{Rank{Filter: RankMin, Size: 5}, 5, 0},
+		{Rank{Filter: RankMax}, 3, 8},
+	}
+
+	for _, tc := range cases {
+		size, index := calculateRank(tc.rank)
+		if size != tc.size || index != tc.index {
+			t.Errorf("Invalid rank for %+v: got %d and %d, expected %d and %d", tc.rank, size, index, tc.size, tc.index)
+		}
+	}
+}
+
+func TestCalculateMorphologyMask(t *testing.T) {
+	width, height, mask, err := calculateMorphologyMask(Morphology{Operation: MorphErode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 3 || height != 3 || len(mask) != 9 || mask[0] != 255 || mask[8] != 255 {
+		t.Errorf("Invalid default mask %dx%d %v", width, height, mask)
+	}
+
+	_, _, mask, err = calculateMorphologyMask(Morphology{Width: 3, Height: 1, Element: []bool{false, true, false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mask[0] != 128 || mask[1] != 255 || mask[2] != 128 {
+		t.Errorf("Invalid element mask %v", mask)
+	}
+
+	if _, _, _, err := calculateMorphologyMask(Morphology{Width: 2, Height: 2, Element: []bool{true}}); err == nil {
+		t.Error("Expected an error for an element not matching its size")
+	}
+}
+
+func TestResizeModulateDesaturate(t *testing.T) {
+	for _, c := range []color.NRGBA{{200, 50, 50, 255}, {30, 180, 90, 255}, {20, 40, 220, 128}} {
+		newImg, err := Resize(solidImage(t, 40, 30, c), Options{Modulate: Modulate{Saturation: -1}})
+		if err != nil {
+			t.Fatalf("Cannot desaturate the image: %s", err)
+		}
+
+		// Full desaturation leaves no chroma at all
+		out := decodePixel(t, newImg, 20, 15)
+		if out.R != out.G || out.G != out.B {
+			t.Errorf("Expected a gray color for %v, got %v", c, out)
+		}
+		if out.A != c.A {
+			t.Errorf("Expected alpha %d, got %d", c.A, out.A)
+		}
+	}
+}
+
+// halvesImage returns an image black on its left half and white on its right half.
+func halvesImage(t *testing.T, width, height int) []byte {
+	return drawImage(t, width, height, func(x, y int) color.NRGBA {
+		if x < width/2 {
+			return color.NRGBA{0, 0, 0, 255}
+		}
+		return color.NRGBA{255, 255, 255, 255}
+	})
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
 func runBenchmarkResize(file string, o Options, b *testing.B) {
 	buf, _ := Read(path.Join("testdata", file))
 
@@ -668,6 +2072,69 @@ func runBenchmarkResize(file string, o Options, b *testing.B) {
 	}
 }
 
+// runPipeline transforms the image without the thumbnail fast path.
+func runPipeline(buf []byte, o Options) ([]byte, error) {
+	image, imageType, err := loadImage(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	image, o, err = resizeImage(image, buf, imageType, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return saveImage(image, o)
+}
+
+func runBenchmarkPipeline(file string, o Options, b *testing.B) {
+	buf, _ := Read(path.Join("testdata", file))
+
+	for n := 0; n < b.N; n++ {
+		runPipeline(buf, o)
+	}
+}
+
+func BenchmarkThumbnailJpeg(b *testing.B) {
+	options := Options{Width: 300, Height: 200}
+	runBenchmarkResize("test.jpg", options, b)
+}
+
+func BenchmarkThumbnailJpegPipeline(b *testing.B) {
+	options := Options{Width: 300, Height: 200}
+	runBenchmarkPipeline("test.jpg", options, b)
+}
+
+func BenchmarkThumbnailCropJpeg(b *testing.B) {
+	options := Options{Width: 300, Height: 300, Crop: true}
+	runBenchmarkResize("test.jpg", options, b)
+}
+
+func BenchmarkThumbnailCropJpegPipeline(b *testing.B) {
+	options := Options{Width: 300, Height: 300, Crop: true}
+	runBenchmarkPipeline("test.jpg", options, b)
+}
+
+func BenchmarkThumbnailHeic(b *testing.B) {
+	options := Options{Width: 300, Type: JPEG}
+	runBenchmarkResize("test.heic", options, b)
+}
+
+func BenchmarkThumbnailHeicPipeline(b *testing.B) {
+	options := Options{Width: 300, Type: JPEG}
+	runBenchmarkPipeline("test.heic", options, b)
+}
+
+func BenchmarkThumbnailPdf(b *testing.B) {
+	options := Options{Width: 300, Type: JPEG}
+	runBenchmarkResize("test.pdf", options, b)
+}
+
+func BenchmarkThumbnailPdfPipeline(b *testing.B) {
+	options := Options{Width: 300, Type: JPEG}
+	runBenchmarkPipeline("test.pdf", options, b)
+}
+
 func BenchmarkRotateJpeg(b *testing.B) {
 	options := Options{Rotate: 180}
 	runBenchmarkResize("test.jpg", options, b)
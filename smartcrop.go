@@ -0,0 +1,53 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"math"
+)
+
+// SmartCropRect returns the area that a smart crop to the given width and height
+// would keep, without cropping the image. The area keeps the requested aspect ratio
+// at the largest size that fits the image, and its coordinates are expressed in
+// pixels of the image after EXIF auto-rotation, the same orientation Resize uses.
+// An optional strategy, which defaults to SmartCropAttention like
+// Options.SmartCropStrategy, must match the one given to Resize for the area to
+// match its crop. The Low and High strategies fall back to the attention strategy
+// with libvips < 8.9.
+func SmartCropRect(buf []byte, width, height int, strategy ...SmartCropStrategy) (Rect, error) {
+	defer C.vips_thread_shutdown()
+
+	var s SmartCropStrategy
+	if len(strategy) > 0 {
+		s = strategy[0]
+	}
+
+	if width <= 0 || height <= 0 {
+		return Rect{}, errors.New("Smart crop width/height params are required")
+	}
+
+	image, _, err := loadImage(buf)
+	if err != nil {
+		return Rect{}, err
+	}
+
+	image, _, err = rotateAndFlipImage(image, &Options{})
+	if err != nil {
+		return Rect{}, err
+	}
+
+	inWidth := int(image.Xsize)
+	inHeight := int(image.Ysize)
+
+	// Scale the requested box to the largest area with the same aspect ratio
+	factor := math.Min(float64(inWidth)/float64(width), float64(inHeight)/float64(height))
+	cropWidth := int(math.Min(float64(roundFloat(float64(width)*factor)), float64(inWidth)))
+	cropHeight := int(math.Min(float64(roundFloat(float64(height)*factor)), float64(inHeight)))
+
+	return vipsSmartCropRect(image, cropWidth, cropHeight, s)
+}
@@ -0,0 +1,31 @@
+package bimg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SrcsetOptions returns the options to render the image at each of the given
+// widths with ResizeMany, based on the given options. When the given options
+// define both width and height, the height is scaled to keep that ratio.
+func SrcsetOptions(o Options, widths ...int) []Options {
+	opts := make([]Options, len(widths))
+	for i, width := range widths {
+		opts[i] = o
+		opts[i].Width = width
+		if o.Width > 0 && o.Height > 0 {
+			opts[i].Height = roundFloat(float64(width) * float64(o.Height) / float64(o.Width))
+		}
+	}
+	return opts
+}
+
+// Srcset returns the value of a srcset attribute for the given images,
+// each one referenced by the URL returned by the given function.
+func Srcset(images []ResizedImage, url func(ResizedImage) string) string {
+	candidates := make([]string, len(images))
+	for i, image := range images {
+		candidates[i] = url(image) + " " + strconv.Itoa(image.Width) + "w"
+	}
+	return strings.Join(candidates, ", ")
+}
@@ -0,0 +1,41 @@
+package bimg
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSrcsetOptions(t *testing.T) {
+	opts := SrcsetOptions(Options{Type: WEBP, Quality: 70}, 320, 640)
+	if len(opts) != 2 {
+		t.Fatalf("Invalid options length: %d", len(opts))
+	}
+	for i, width := range []int{320, 640} {
+		if opts[i].Width != width || opts[i].Height != 0 || opts[i].Type != WEBP || opts[i].Quality != 70 {
+			t.Errorf("Invalid options: %#v", opts[i])
+		}
+	}
+
+	opts = SrcsetOptions(Options{Width: 1600, Height: 900, Crop: true}, 320, 1000)
+	if opts[0].Height != 180 || opts[1].Height != 563 || !opts[1].Crop {
+		t.Errorf("Invalid options height: %d, %d", opts[0].Height, opts[1].Height)
+	}
+}
+
+func TestSrcset(t *testing.T) {
+	images := []ResizedImage{
+		{Type: JPEG, Width: 320, Height: 200},
+		{Type: WEBP, Width: 640, Height: 400},
+	}
+
+	srcset := Srcset(images, func(image ResizedImage) string {
+		return fmt.Sprintf("/img-%d.%s", image.Width, ImageTypeName(image.Type))
+	})
+	if srcset != "/img-320.jpeg 320w, /img-640.webp 640w" {
+		t.Errorf("Invalid srcset: %s", srcset)
+	}
+
+	if Srcset(nil, nil) != "" {
+		t.Error("Invalid empty srcset")
+	}
+}
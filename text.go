@@ -0,0 +1,146 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// shouldUseTextLayer reports whether the text watermark needs any of the
+// features which the legacy text mask blending does not support.
+func shouldUseTextLayer(w Watermark) bool {
+	return w.Foreground.A > 0 || w.Outline.A > 0 && w.OutlineWidth > 0 || w.Align != AlignLeft ||
+		w.UseGravity || w.Angle != 0 || w.FontFile != "" || len(w.FontBuf) > 0
+}
+
+// watermarkImageWithTextLayer renders the text as a colored layer, which is
+// rotated, placed or tiled, and then composited over the image.
+func watermarkImageWithTextLayer(image *C.VipsImage, w Watermark) (*C.VipsImage, error) {
+	var err error
+	inWidth := int(image.Xsize)
+	inHeight := int(image.Ysize)
+
+	fontFile := w.FontFile
+	if len(w.FontBuf) > 0 {
+		fontFile, err = writeFontFile(w.FontBuf)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+	}
+
+	mask, err := vipsText(w.Text, w.Font, fontFile, w.Width, w.DPI, w.Align)
+	if err != nil {
+		C.g_object_unref(C.gpointer(image))
+		return nil, err
+	}
+
+	// Leave room for the outline around the text
+	outline := 0
+	if w.Outline.A > 0 {
+		outline = w.OutlineWidth
+	}
+	if outline > 0 {
+		mask, err = vipsEmbed(mask, outline, outline, int(mask.Xsize)+2*outline, int(mask.Ysize)+2*outline, ExtendBlack, ColorBlack)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+	}
+
+	if w.Angle != 0 {
+		mask, err = vipsSimilarity(mask, w.Angle, Bicubic, ColorBlack, false)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+	}
+
+	// The background color is the text color of the legacy watermarks
+	fill := w.Foreground
+	if fill.A == 0 {
+		fill = RGBA{w.Background.R, w.Background.G, w.Background.B, 255}
+	}
+
+	layer, err := vipsTextLayer(mask, outline, fill, w.Outline, w.Opacity)
+	if err != nil {
+		C.g_object_unref(C.gpointer(image))
+		return nil, err
+	}
+
+	// Same placement as the legacy watermarks, unless placed by gravity
+	left, top := 100, 100
+	switch {
+	case w.UseGravity:
+		left, top = calculateWatermarkPosition(inWidth, inHeight, int(layer.Xsize), int(layer.Ysize), w.Gravity, w.Margin)
+	case !w.NoReplicate:
+		left, top = 0, 0
+		layer, err = vipsWatermarkTile(layer, inWidth, inHeight, w.Margin)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+	}
+	defer C.g_object_unref(C.gpointer(layer))
+
+	return vipsComposite(image, []*C.VipsImage{layer}, []Layer{{Gravity: GravityNorthWest, Left: left, Top: top, Opacity: 1}})
+}
+
+var (
+	fontMutex sync.Mutex
+	fontDir   string
+	fontFiles = map[string]string{}
+)
+
+// writeFontFile stores the font in a temporary directory of the process, named
+// by its checksum, so each distinct font is written and registered only once.
+// Every distinct font is kept until Shutdown removes the directory.
+func writeFontFile(buf []byte) (string, error) {
+	sum := sha1.Sum(buf)
+	name := hex.EncodeToString(sum[:])
+
+	fontMutex.Lock()
+	defer fontMutex.Unlock()
+
+	if path, ok := fontFiles[name]; ok {
+		return path, nil
+	}
+
+	if fontDir == "" {
+		dir, err := ioutil.TempDir("", "bimg-fonts-")
+		if err != nil {
+			return "", err
+		}
+		fontDir = dir
+	}
+
+	// The font is only readable by the process, and fully written before use
+	path := filepath.Join(fontDir, name)
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		return "", err
+	}
+
+	fontFiles[name] = path
+	return path, nil
+}
+
+// removeFontFiles removes the temporary directory of the fonts.
+func removeFontFiles() {
+	fontMutex.Lock()
+	defer fontMutex.Unlock()
+
+	if fontDir != "" {
+		os.RemoveAll(fontDir)
+	}
+	fontDir = ""
+	fontFiles = map[string]string{}
+}
@@ -0,0 +1,179 @@
+package bimg
+
+import (
+	"bytes"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatermarkTextLayer(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	newImg, err := Resize(buf, Options{
+		Width:  800,
+		Height: 600,
+		Crop:   true,
+		Watermark: Watermark{
+			Text:       "<b>bimg</b>",
+			Width:      400,
+			DPI:        300,
+			Opacity:    1,
+			Foreground: RGBA{255, 0, 0, 255},
+			UseGravity: true,
+			Gravity:    GravityNorthWest,
+			Margin:     10,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Cannot watermark the image: %s", err)
+	}
+
+	if err := assertSize(newImg, 800, 600); err != nil {
+		t.Error(err)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(newImg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The text is only painted close to the top left corner
+	red := func(x0, y0, x1, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				if r>>8 > 200 && g>>8 < 50 && b>>8 < 50 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !red(10, 10, 410, 300) {
+		t.Error("Expected the text in the top left corner")
+	}
+	if red(0, 0, 800, 10) || red(0, 0, 10, 600) {
+		t.Error("Expected the text within the margins")
+	}
+}
+
+func TestWatermarkTextLayerOptions(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+
+	tests := []Watermark{
+		{Text: "Copy me if you can", Angle: -45, Foreground: RGBA{255, 255, 255, 128}},
+		{Text: "Copy me\nif you can", Align: AlignCentre, NoReplicate: true},
+		{Text: "Copy me if you can", Outline: RGBA{0, 0, 0, 255}, OutlineWidth: 2, UseGravity: true, Gravity: GravitySouthEast},
+	}
+
+	for _, test := range tests {
+		newImg, err := Resize(buf, Options{Watermark: test})
+		if err != nil {
+			t.Fatalf("Cannot watermark the image with %#v: %s", test, err)
+		}
+
+		if err := assertSize(newImg, 1680, 1050); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestWatermarkTextFontFile(t *testing.T) {
+	if !(VipsMajorVersion >= 8 && VipsMinorVersion >= 9) {
+		t.Skipf("Skipping this test, libvips doesn't meet version requirement %s >= 8.9", VipsVersion)
+	}
+
+	fonts, _ := filepath.Glob("/usr/share/fonts/*/*/*.ttf")
+	if len(fonts) == 0 {
+		t.Skip("Skipping this test, no TTF font found")
+	}
+
+	font, err := ioutil.ReadFile(fonts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, _ := Read("testdata/test.jpg")
+	for _, w := range []Watermark{{Text: "bimg", FontFile: fonts[0]}, {Text: "bimg", FontBuf: font}} {
+		if _, err := Resize(buf, Options{Watermark: w}); err != nil {
+			t.Errorf("Cannot watermark the image with a font file: %s", err)
+		}
+	}
+}
+
+func TestWriteFontFile(t *testing.T) {
+	font := []byte("not really a font")
+
+	path, err := writeFontFile(font)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := writeFontFile(font)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != path {
+		t.Errorf("Expected the same font file: %s, %s", path, again)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if !bytes.Equal(data, font) {
+		t.Error("Invalid font file content")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("Invalid font file mode: %o", mode)
+	}
+
+	other, _ := writeFontFile([]byte("another font"))
+	if other == path {
+		t.Error("Expected a different font file")
+	}
+	if filepath.Dir(other) != filepath.Dir(path) {
+		t.Errorf("Expected the fonts in the same directory: %s, %s", path, other)
+	}
+
+	// The directory of the fonts is removed, and created again when needed
+	removeFontFiles()
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected the font directory to be removed: %v", err)
+	}
+	again, err = writeFontFile(font)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(again); err != nil {
+		t.Errorf("Expected the font file to be written again: %s", err)
+	}
+}
+
+func TestShouldUseTextLayer(t *testing.T) {
+	tests := []struct {
+		watermark Watermark
+		expected  bool
+	}{
+		{Watermark{Text: "bimg", Background: Color{255, 0, 0}, Margin: 10, NoReplicate: true}, false},
+		{Watermark{Text: "bimg", OutlineWidth: 2}, false},
+		{Watermark{Text: "bimg", Foreground: RGBA{A: 255}}, true},
+		{Watermark{Text: "bimg", Outline: RGBA{A: 255}, OutlineWidth: 2}, true},
+		{Watermark{Text: "bimg", Align: AlignRight}, true},
+		{Watermark{Text: "bimg", UseGravity: true}, true},
+		{Watermark{Text: "bimg", Angle: 45}, true},
+		{Watermark{Text: "bimg", FontFile: "brand.ttf"}, true},
+		{Watermark{Text: "bimg", FontBuf: []byte{0}}, true},
+	}
+
+	for _, test := range tests {
+		if shouldUseTextLayer(test.watermark) != test.expected {
+			t.Errorf("Invalid text layer usage for %#v, expected %t", test.watermark, test.expected)
+		}
+	}
+}
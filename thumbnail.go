@@ -0,0 +1,101 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+)
+
+// calculateThumbnail returns the options to render the image with the libvips
+// thumbnail operation, which shrinks on load every format supporting it, such
+// as HEIF, PDF, SVG or TIFF pyramids. It only applies to plain resizes and crops,
+// which the thumbnail operation renders like the transformation pipeline.
+func calculateThumbnail(image *C.VipsImage, o Options) (Options, bool) {
+	if !(VipsMajorVersion >= 8 && VipsMinorVersion >= 7) {
+		return o, false
+	}
+
+	if o.NoAutoRotate || o.Rotate != 0 || o.Flip || o.Flop || o.Rotation != (Rotation{}) || o.Deskew ||
+		shouldWarpImage(o) || o.Zoom != 0 || o.Trim || o.Embed ||
+		o.Top != 0 || o.Left != 0 || o.AreaWidth != 0 || o.AreaHeight != 0 ||
+		o.Kernel != KernelLanczos3 || o.Interpolator != Bicubic || o.LinearLight || o.InputICC != "" ||
+		shouldApplyEffects(o) || o.Padding != (Padding{}) || o.Border.Width > 0 || shouldApplyMask(o) ||
+		o.Watermark.Text != "" || len(o.WatermarkImage.Buf) > 0 || len(o.Layers) > 0 || o.Caption.Text != "" || len(o.Redactions) > 0 {
+		return o, false
+	}
+
+	// Crops are either centered or smart
+	smart := o.Gravity == GravitySmart || o.SmartCrop
+	if o.Crop && (o.FocalPoint != nil || !smart && o.Gravity != GravityCentre) {
+		return o, false
+	}
+
+	// The thumbnail operation only converts the colour space of sRGB or B_W images like the pipeline
+	interpretation := vipsInterpretation(image)
+	if interpretation != InterpretationSRGB && interpretation != InterpretationBW {
+		return o, false
+	}
+
+	// Calculate the output size on the auto rotated image
+	inWidth := int(image.Xsize)
+	inHeight := int(image.Ysize)
+	rotate, flip := calculateRotationAndFlip(image, o.Rotate)
+	if flip {
+		return o, false
+	}
+	if rotate == D90 || rotate == D270 {
+		inWidth, inHeight = inHeight, inWidth
+	}
+
+	normalizeOperation(&o, inWidth, inHeight)
+	imageCalculations(&o, inWidth, inHeight)
+
+	switch {
+	case o.Crop:
+		// Crop areas larger than the image are left to the pipeline
+		return o, !o.Force && inWidth >= o.Width && inHeight >= o.Height
+	case o.Force, o.Enlarge:
+		return o, o.Width > 0 && o.Height > 0
+	}
+
+	return o, false
+}
+
+// thumbnailImage renders the image from the given buffer with the libvips thumbnail
+// operation, returning it along with the options used to save it.
+func thumbnailImage(buf []byte, imageType ImageType, o Options) (*C.VipsImage, Options, error) {
+	// Clone and define default options
+	o = applyDefaults(o, imageType)
+
+	if !IsTypeSupported(o.Type) {
+		return nil, o, errors.New("Unsupported image output type")
+	}
+
+	strategy := SmartCropCentre
+	if o.Gravity == GravitySmart || o.SmartCrop {
+		strategy = o.SmartCropStrategy
+	}
+
+	image, err := vipsThumbnail(buf, o.Width, o.Height, o.Crop, strategy, o.Force)
+	if err != nil {
+		return nil, o, err
+	}
+
+	// Flatten image on a background, if necessary
+	image, err = imageFlatten(image, imageType, o)
+	if err != nil {
+		return nil, o, err
+	}
+
+	// Apply Gamma filter, if necessary
+	image, err = applyGamma(image, o)
+	if err != nil {
+		return nil, o, err
+	}
+
+	return image, o, nil
+}
@@ -0,0 +1,140 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"math"
+)
+
+// AffineIdentity is the affine matrix that leaves the image unchanged.
+var AffineIdentity = Affine{1, 0, 0, 0, 1, 0}
+
+// AffineScale returns the affine matrix that scales by the given factors.
+func AffineScale(x, y float64) Affine {
+	return Affine{x, 0, 0, 0, y, 0}
+}
+
+// AffineRotate returns the affine matrix that rotates by the given clockwise degrees.
+func AffineRotate(angle float64) Affine {
+	radians := angle * math.Pi / 180
+	sin, cos := math.Sin(radians), math.Cos(radians)
+	return Affine{cos, -sin, 0, sin, cos, 0}
+}
+
+// AffineShear returns the affine matrix that shears by the given horizontal and vertical factors.
+func AffineShear(x, y float64) Affine {
+	return Affine{1, x, 0, y, 1, 0}
+}
+
+// Then returns the affine matrix that applies a followed by b.
+func (a Affine) Then(b Affine) Affine {
+	return Affine{
+		b[0]*a[0] + b[1]*a[3],
+		b[0]*a[1] + b[1]*a[4],
+		b[0]*a[2] + b[1]*a[5] + b[2],
+		b[3]*a[0] + b[4]*a[3],
+		b[3]*a[1] + b[4]*a[4],
+		b[3]*a[2] + b[4]*a[5] + b[5],
+	}
+}
+
+func shouldWarpImage(o Options) bool {
+	return o.Affine != (Affine{}) || o.Perspective.Points != [4]Point{}
+}
+
+func warpImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
+	var err error
+
+	if o.Affine != (Affine{}) {
+		image, err = vipsAffineMatrix(image, o.Affine, o.Interpolator, o.Extend, o.Background)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Perspective.Points != [4]Point{} {
+		width, height := calculatePerspectiveSize(o.Perspective)
+		h, err := calculateHomography(o.Perspective.Points, width, height)
+		if err != nil {
+			return nil, err
+		}
+
+		image, err = vipsPerspective(image, h, width, height, o.Interpolator, o.Extend, o.Background)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return image, nil
+}
+
+// calculatePerspectiveSize returns the output size of a perspective warp,
+// using the longest opposite quad edges when no size is given.
+func calculatePerspectiveSize(p Perspective) (int, int) {
+	distance := func(a, b Point) float64 {
+		return math.Hypot(b.X-a.X, b.Y-a.Y)
+	}
+
+	tl, tr, br, bl := p.Points[0], p.Points[1], p.Points[2], p.Points[3]
+	width, height := p.Width, p.Height
+	if width <= 0 {
+		width = roundFloat(math.Max(distance(tl, tr), distance(bl, br)))
+	}
+	if height <= 0 {
+		height = roundFloat(math.Max(distance(tl, bl), distance(tr, br)))
+	}
+
+	return int(math.Max(float64(width), 2)), int(math.Max(float64(height), 2))
+}
+
+// calculateHomography returns the row-major 3x3 projective matrix mapping the
+// corners of a width x height rectangle to the given quad points.
+func calculateHomography(points [4]Point, width, height int) ([9]float64, error) {
+	w, h := float64(width-1), float64(height-1)
+	corners := [4]Point{{0, 0}, {w, 0}, {w, h}, {0, h}}
+
+	// Each correspondence gives two rows of the 8x8 linear system, with h8 = 1
+	var a [8][9]float64
+	for i, c := range corners {
+		x, y := points[i].X, points[i].Y
+		a[2*i] = [9]float64{c.X, c.Y, 1, 0, 0, 0, -c.X * x, -c.Y * x, x}
+		a[2*i+1] = [9]float64{0, 0, 0, c.X, c.Y, 1, -c.X * y, -c.Y * y, y}
+	}
+
+	// Gaussian elimination with partial pivoting
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for row := col + 1; row < 8; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return [9]float64{}, errors.New("Perspective points must form a valid quad")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+
+		for row := 0; row < 8; row++ {
+			if row == col {
+				continue
+			}
+			factor := a[row][col] / a[col][col]
+			for k := col; k < 9; k++ {
+				a[row][k] -= factor * a[col][k]
+			}
+		}
+	}
+
+	var matrix [9]float64
+	for i := 0; i < 8; i++ {
+		matrix[i] = a[i][8] / a[i][i]
+	}
+	matrix[8] = 1
+
+	return matrix, nil
+}
@@ -0,0 +1,106 @@
+package bimg
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAffineTransform(t *testing.T) {
+	buf, _ := Read("testdata/test.jpg")
+	size, _ := Size(buf)
+
+	tests := []struct {
+		affine        Affine
+		width, height int
+	}{
+		{AffineIdentity, size.Width, size.Height},
+		{AffineScale(0.5, 0.25), size.Width / 2, size.Height / 4},
+		{AffineShear(0.2, 0), size.Width + roundFloat(0.2*float64(size.Height)), size.Height},
+	}
+
+	for _, test := range tests {
+		options := Options{Affine: test.affine, Interpolator: Bilinear, Background: Color{255, 255, 255}, Extend: ExtendBackground}
+		newImg, err := Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		newSize, _ := Size(newImg)
+		if math.Abs(float64(newSize.Width-test.width)) > 1 || math.Abs(float64(newSize.Height-test.height)) > 1 {
+			t.Errorf("Invalid image size: %dx%d, expected %dx%d", newSize.Width, newSize.Height, test.width, test.height)
+		}
+	}
+}
+
+func TestPerspectiveTransform(t *testing.T) {
+	if !(VipsMajorVersion >= 8 && VipsMinorVersion >= 7) {
+		t.Skipf("Skipping this test, libvips doesn't meet version requirement %s >= 8.7", VipsVersion)
+	}
+
+	buf, _ := Read("testdata/test.jpg")
+	options := Options{Perspective: Perspective{
+		Points: [4]Point{{120, 80}, {1200, 40}, {1300, 600}, {60, 650}},
+		Width:  800,
+		Height: 500,
+	}}
+
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	size, _ := Size(newImg)
+	if size.Width != 800 || size.Height != 500 {
+		t.Errorf("Invalid image size: %dx%d", size.Width, size.Height)
+	}
+
+	Write("testdata/test_perspective_out.jpg", newImg)
+}
+
+func TestAffineThen(t *testing.T) {
+	m := AffineScale(2, 3).Then(AffineShear(1, 0)).Then(AffineRotate(90))
+
+	// (1, 1) -> (2, 3) -> (5, 3) -> (-3, 5)
+	x := m[0]*1 + m[1]*1 + m[2]
+	y := m[3]*1 + m[4]*1 + m[5]
+	if math.Abs(x+3) > 1e-9 || math.Abs(y-5) > 1e-9 {
+		t.Errorf("Invalid affine composition: %f,%f", x, y)
+	}
+}
+
+func TestCalculatePerspectiveSize(t *testing.T) {
+	p := Perspective{Points: [4]Point{{0, 0}, {300, 0}, {320, 200}, {10, 210}}}
+	width, height := calculatePerspectiveSize(p)
+	if width != 310 || height != 210 {
+		t.Errorf("Invalid perspective size: %dx%d", width, height)
+	}
+
+	p.Width, p.Height = 100, 50
+	width, height = calculatePerspectiveSize(p)
+	if width != 100 || height != 50 {
+		t.Errorf("Invalid perspective size: %dx%d", width, height)
+	}
+}
+
+func TestCalculateHomography(t *testing.T) {
+	points := [4]Point{{10, 20}, {400, 5}, {420, 300}, {0, 280}}
+	h, err := calculateHomography(points, 201, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corners := [4]Point{{0, 0}, {200, 0}, {200, 100}, {0, 100}}
+	for i, c := range corners {
+		w := h[6]*c.X + h[7]*c.Y + h[8]
+		x := (h[0]*c.X + h[1]*c.Y + h[2]) / w
+		y := (h[3]*c.X + h[4]*c.Y + h[5]) / w
+		if math.Abs(x-points[i].X) > 1e-6 || math.Abs(y-points[i].Y) > 1e-6 {
+			t.Errorf("Invalid mapping of corner %d: %f,%f, expected %#v", i, x, y, points[i])
+		}
+	}
+
+	_, err = calculateHomography([4]Point{{0, 0}, {0, 0}, {0, 0}, {0, 0}}, 10, 10)
+	if err == nil {
+		t.Error("Expected error for a degenerate quad")
+	}
+}
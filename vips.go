@@ -119,6 +119,7 @@ func Initialize() {
 
 // Shutdown is used to shutdown libvips in a thread-safe way.
 // You can call this to drop caches as well.
+// It also removes the font files written for the FontBuf options.
 // If libvips was already initialized, the function is no-op
 func Shutdown() {
 	m.Lock()
@@ -128,6 +129,7 @@ func Shutdown() {
 		C.vips_shutdown()
 		initialized = false
 	}
+	removeFontFiles()
 }
 
 // VipsCacheSetMaxMem Sets the maximum amount of tracked memory allowed before the vips operation cache
@@ -385,6 +387,42 @@ func vipsInterpretation(image *C.VipsImage) Interpretation {
 	return Interpretation(C.vips_image_guess_interpretation_bridge(image))
 }
 
+func vipsColourspace(image *C.VipsImage, interpretation Interpretation) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	err := C.vips_colourspace_bridge(image, &out, C.VipsInterpretation(interpretation))
+	if int(err) != 0 {
+		return nil, catchVipsError()
+	}
+
+	return out, nil
+}
+
+func vipsPremultiply(image *C.VipsImage) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	err := C.vips_premultiply_bridge(image, &out)
+	if int(err) != 0 {
+		return nil, catchVipsError()
+	}
+
+	return out, nil
+}
+
+func vipsUnpremultiply(image *C.VipsImage, format C.VipsBandFormat) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	err := C.vips_unpremultiply_bridge(image, &out, format)
+	if int(err) != 0 {
+		return nil, catchVipsError()
+	}
+
+	return out, nil
+}
+
 func vipsFlattenBackground(image *C.VipsImage, background Color) (*C.VipsImage, error) {
 	var outImage *C.VipsImage
 
@@ -550,7 +588,7 @@ func vipsExtract(image *C.VipsImage, left, top, width, height int) (*C.VipsImage
 	return buf, nil
 }
 
-func vipsSmartCrop(image *C.VipsImage, width, height int) (*C.VipsImage, error) {
+func vipsSmartCrop(image *C.VipsImage, width, height int, strategy SmartCropStrategy) (*C.VipsImage, error) {
 	var buf *C.VipsImage
 	defer C.g_object_unref(C.gpointer(image))
 
@@ -558,7 +596,7 @@ func vipsSmartCrop(image *C.VipsImage, width, height int) (*C.VipsImage, error)
 		return nil, errors.New("Maximum image size exceeded")
 	}
 
-	err := C.vips_smartcrop_bridge(image, &buf, C.int(width), C.int(height))
+	err := C.vips_smartcrop_bridge(image, &buf, C.int(width), C.int(height), C.int(strategy))
 	if err != 0 {
 		return nil, catchVipsError()
 	}
@@ -566,6 +604,37 @@ func vipsSmartCrop(image *C.VipsImage, width, height int) (*C.VipsImage, error)
 	return buf, nil
 }
 
+func vipsThumbnail(buf []byte, width, height int, crop bool, strategy SmartCropStrategy, force bool) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	var ptr = unsafe.Pointer(&buf[0])
+
+	if width > MaxSize || height > MaxSize {
+		return nil, errors.New("Maximum image size exceeded")
+	}
+
+	err := C.vips_thumbnail_bridge(ptr, C.size_t(len(buf)), &image, C.int(width), C.int(height),
+		C.int(boolToInt(crop)), C.int(strategy), C.int(boolToInt(force)))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsSmartCropRect(image *C.VipsImage, width, height int, strategy SmartCropStrategy) (Rect, error) {
+	defer C.g_object_unref(C.gpointer(image))
+
+	left := C.int(0)
+	top := C.int(0)
+
+	err := C.vips_smartcrop_rect_bridge(image, C.int(width), C.int(height), C.int(strategy), &left, &top)
+	if err != 0 {
+		return Rect{}, catchVipsError()
+	}
+
+	return Rect{Left: int(left), Top: int(top), Width: width, Height: height}, nil
+}
+
 func vipsTrim(image *C.VipsImage, background Color, threshold float64) (int, int, int, int, error) {
 	defer C.g_object_unref(C.gpointer(image))
 
@@ -585,6 +654,17 @@ func vipsTrim(image *C.VipsImage, background Color, threshold float64) (int, int
 	return int(top), int(left), int(width), int(height), nil
 }
 
+func vipsCopyMemory(input *C.VipsImage) (*C.VipsImage, error) {
+	defer C.g_object_unref(C.gpointer(input))
+
+	image := C.vips_image_copy_memory(input)
+	if image == nil {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
 func vipsShrinkJpeg(buf []byte, input *C.VipsImage, shrink int) (*C.VipsImage, error) {
 	var image *C.VipsImage
 	var ptr = unsafe.Pointer(&buf[0])
@@ -611,6 +691,76 @@ func vipsShrinkWebp(buf []byte, input *C.VipsImage, shrink int) (*C.VipsImage, e
 	return image, nil
 }
 
+func vipsShrinkHeif(buf []byte, input *C.VipsImage, width, height int) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	var ptr = unsafe.Pointer(&buf[0])
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_heifload_buffer_shrink(ptr, C.size_t(len(buf)), &image, C.int(width), C.int(height))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsShrinkPdf(buf []byte, input *C.VipsImage, shrink int) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	var ptr = unsafe.Pointer(&buf[0])
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_pdfload_buffer_shrink(ptr, C.size_t(len(buf)), &image, C.int(shrink))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsShrinkSvg(buf []byte, input *C.VipsImage, shrink int) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	var ptr = unsafe.Pointer(&buf[0])
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_svgload_buffer_shrink(ptr, C.size_t(len(buf)), &image, C.int(shrink))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsShrinkTiff(buf []byte, input *C.VipsImage, shrink int) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	var ptr = unsafe.Pointer(&buf[0])
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_tiffload_buffer_shrink(input, ptr, C.size_t(len(buf)), &image, C.int(shrink))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+// vipsSaveTiffLayout saves the image as a pyramidal TIFF, or as a multi-page
+// TIFF if pageHeight is set, to build the shrink-on-load test fixtures.
+func vipsSaveTiffLayout(image *C.VipsImage, pyramid, subifd bool, pageHeight int) ([]byte, error) {
+	var ptr unsafe.Pointer
+	length := C.size_t(0)
+	defer C.g_object_unref(C.gpointer(image))
+
+	err := C.vips_tiffsave_layout_bridge(image, &ptr, &length, C.int(boolToInt(pyramid)), C.int(boolToInt(subifd)), C.int(pageHeight))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	buf := C.GoBytes(ptr, C.int(length))
+	C.g_free(C.gpointer(ptr))
+
+	return buf, nil
+}
+
 func vipsShrink(input *C.VipsImage, shrink int) (*C.VipsImage, error) {
 	var image *C.VipsImage
 	defer C.g_object_unref(C.gpointer(input))
@@ -623,11 +773,13 @@ func vipsShrink(input *C.VipsImage, shrink int) (*C.VipsImage, error) {
 	return image, nil
 }
 
-func vipsReduce(input *C.VipsImage, xshrink float64, yshrink float64) (*C.VipsImage, error) {
+func vipsReduce(input *C.VipsImage, xshrink float64, yshrink float64, k Kernel) (*C.VipsImage, error) {
 	var image *C.VipsImage
+	kernel := C.CString(k.String())
+	defer C.free(unsafe.Pointer(kernel))
 	defer C.g_object_unref(C.gpointer(input))
 
-	err := C.vips_reduce_bridge(input, &image, C.double(xshrink), C.double(yshrink))
+	err := C.vips_reduce_bridge(input, &image, C.double(xshrink), C.double(yshrink), kernel)
 	if err != 0 {
 		return nil, catchVipsError()
 	}
@@ -653,6 +805,62 @@ func vipsEmbed(input *C.VipsImage, left, top, width, height int, extend Extend,
 	return image, nil
 }
 
+func vipsBorder(input *C.VipsImage, width int, color Color) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+
+	if int(input.Xsize)+2*width > MaxSize || int(input.Ysize)+2*width > MaxSize {
+		return nil, errors.New("Maximum image size exceeded")
+	}
+
+	err := C.vips_border_bridge(input, &image, C.int(width), C.double(color.R), C.double(color.G), C.double(color.B))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsMaskFromShape(shape MaskShape, width, height, radius int) (*C.VipsImage, error) {
+	var image *C.VipsImage
+
+	err := C.vips_mask_shape_bridge(&image, C.int(width), C.int(height), C.int(shape), C.double(radius))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsMaskFromBuffer(buf []byte, width, height int) (*C.VipsImage, error) {
+	var image *C.VipsImage
+
+	mask, _, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(mask))
+
+	if C.vips_mask_image_bridge(mask, &image, C.int(width), C.int(height)) != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsApplyMask(input *C.VipsImage, mask *C.VipsImage) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+	defer C.g_object_unref(C.gpointer(mask))
+
+	err := C.vips_apply_mask_bridge(input, mask, &image)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
 func vipsAffine(input *C.VipsImage, residualx, residualy float64, i Interpolator, extend Extend) (*C.VipsImage, error) {
 	if extend > 5 {
 		extend = ExtendBackground
@@ -674,6 +882,96 @@ func vipsAffine(input *C.VipsImage, residualx, residualy float64, i Interpolator
 	return image, nil
 }
 
+func vipsSimilarity(input *C.VipsImage, angle float64, i Interpolator, background Color, transparent bool) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	cstring := C.CString(i.String())
+	interpolator := C.vips_interpolate_new(cstring)
+
+	defer C.free(unsafe.Pointer(cstring))
+	defer C.g_object_unref(C.gpointer(input))
+	defer C.g_object_unref(C.gpointer(interpolator))
+
+	err := C.vips_similarity_bridge(input, &image, C.double(angle), interpolator, C.int(boolToInt(transparent)),
+		C.double(background.R), C.double(background.G), C.double(background.B))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsGreyPixels(image *C.VipsImage) ([]byte, error) {
+	var ptr unsafe.Pointer
+	defer C.g_object_unref(C.gpointer(image))
+
+	length := C.size_t(0)
+	err := C.vips_grey_memory_bridge(image, &ptr, &length)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	defer C.g_free(C.gpointer(ptr))
+
+	return C.GoBytes(ptr, C.int(length)), nil
+}
+
+func vipsAffineMatrix(input *C.VipsImage, m Affine, i Interpolator, extend Extend, background Color) (*C.VipsImage, error) {
+	if extend > 5 {
+		extend = ExtendBackground
+	}
+
+	var image *C.VipsImage
+	cstring := C.CString(i.String())
+	interpolator := C.vips_interpolate_new(cstring)
+
+	defer C.free(unsafe.Pointer(cstring))
+	defer C.g_object_unref(C.gpointer(input))
+	defer C.g_object_unref(C.gpointer(interpolator))
+
+	var matrix [6]C.double
+	for n, value := range m {
+		matrix[n] = C.double(value)
+	}
+
+	err := C.vips_affine_matrix_bridge(input, &image, &matrix[0], interpolator, C.int(extend),
+		C.double(background.R), C.double(background.G), C.double(background.B))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsPerspective(input *C.VipsImage, h [9]float64, width, height int, i Interpolator, extend Extend, background Color) (*C.VipsImage, error) {
+	if extend > 5 {
+		extend = ExtendBackground
+	}
+
+	var image *C.VipsImage
+	cstring := C.CString(i.String())
+	interpolator := C.vips_interpolate_new(cstring)
+
+	defer C.free(unsafe.Pointer(cstring))
+	defer C.g_object_unref(C.gpointer(input))
+	defer C.g_object_unref(C.gpointer(interpolator))
+
+	if width > MaxSize || height > MaxSize {
+		return nil, errors.New("Maximum image size exceeded")
+	}
+
+	var matrix [9]C.double
+	for n, value := range h {
+		matrix[n] = C.double(value)
+	}
+
+	err := C.vips_perspective_bridge(input, &image, &matrix[0], C.int(width), C.int(height), interpolator, C.int(extend),
+		C.double(background.R), C.double(background.G), C.double(background.B))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
 func vipsImageType(buf []byte) ImageType {
 	if len(buf) < 12 {
 		return UNKNOWN
@@ -775,7 +1073,13 @@ func vipsDrawWatermark(image *C.VipsImage, o WatermarkImage) (*C.VipsImage, erro
 		return nil, e
 	}
 
-	opts := vipsWatermarkImageOptions{C.int(o.Left), C.int(o.Top), C.float(o.Opacity)}
+	// Scale, tile and place the watermark, if necessary
+	watermark, left, top, e := placeWatermarkImage(image, watermark, o)
+	if e != nil {
+		return nil, e
+	}
+
+	opts := vipsWatermarkImageOptions{C.int(left), C.int(top), C.float(o.Opacity)}
 
 	err := C.vips_watermark_image(image, watermark, &out, (*C.WatermarkImageOptions)(unsafe.Pointer(&opts)))
 
@@ -786,6 +1090,282 @@ func vipsDrawWatermark(image *C.VipsImage, o WatermarkImage) (*C.VipsImage, erro
 	return out, nil
 }
 
+func vipsComposite(input *C.VipsImage, overlays []*C.VipsImage, layers []Layer) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+
+	n := len(overlays)
+	modes := make([]C.int, n)
+	left := make([]C.int, n)
+	top := make([]C.int, n)
+	opacity := make([]C.double, n)
+
+	for i, layer := range layers {
+		x, y := calculateLayerPosition(int(input.Xsize), int(input.Ysize), int(overlays[i].Xsize), int(overlays[i].Ysize), layer)
+		modes[i] = C.int(layer.Blend)
+		left[i] = C.int(x)
+		top[i] = C.int(y)
+		opacity[i] = C.double(layer.Opacity)
+	}
+
+	err := C.vips_composite_bridge(input, &overlays[0], C.int(n), &modes[0], &left[0], &top[0], &opacity[0], &image)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsText(text, font, fontFile string, width, dpi int, align Align) (*C.VipsImage, error) {
+	var image *C.VipsImage
+
+	ctext := C.CString(text)
+	cfont := C.CString(font)
+	cfontFile := C.CString(fontFile)
+	defer C.free(unsafe.Pointer(ctext))
+	defer C.free(unsafe.Pointer(cfont))
+	defer C.free(unsafe.Pointer(cfontFile))
+
+	err := C.vips_text_bridge(&image, ctext, cfont, cfontFile, C.int(width), C.int(dpi), C.int(align))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsTextLayer(mask *C.VipsImage, outline int, fill, stroke RGBA, opacity float32) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(mask))
+
+	fillColour := [4]C.double{C.double(fill.R), C.double(fill.G), C.double(fill.B), C.double(fill.A)}
+	strokeColour := [4]C.double{C.double(stroke.R), C.double(stroke.G), C.double(stroke.B), C.double(stroke.A)}
+
+	err := C.vips_text_layer_bridge(mask, &image, C.int(outline), &fillColour[0], &strokeColour[0], C.double(opacity))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsBoxLayer(width, height int, colour RGBA) (*C.VipsImage, error) {
+	var image *C.VipsImage
+
+	if width > MaxSize || height > MaxSize {
+		return nil, errors.New("Maximum image size exceeded")
+	}
+
+	fill := [4]C.double{C.double(colour.R), C.double(colour.G), C.double(colour.B), C.double(colour.A)}
+	err := C.vips_box_layer_bridge(&image, C.int(width), C.int(height), &fill[0])
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsWatermarkTile(input *C.VipsImage, width, height, margin int) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_watermark_tile_bridge(input, &image, C.int(width), C.int(height), C.int(margin))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsModulate(image *C.VipsImage, m Modulate) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	// The adjustments are relative, so zero leaves the image unchanged
+	brightness := math.Max(1+m.Brightness, 0)
+	saturation := math.Max(1+m.Saturation, 0)
+
+	err := C.vips_modulate_bridge(image, &out, C.double(brightness), C.double(saturation), C.double(m.Hue))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsLinear(image *C.VipsImage, l Linear) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	contrast := math.Max(1+l.Contrast, 0)
+
+	err := C.vips_linear_bridge(image, &out, C.double(contrast), C.double(l.Brightness))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsNormalize(image *C.VipsImage, n Normalize) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	var err C.int
+	if n == NormalizeBands {
+		err = C.vips_normalize_bands_bridge(image, &out, 1, 99)
+	} else {
+		err = C.vips_luminance_bridge(image, &out, C.LUMINANCE_NORMALIZE, 0, 0, 0, 1, 99)
+	}
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsEqualize(image *C.VipsImage) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	err := C.vips_luminance_bridge(image, &out, C.LUMINANCE_EQUALIZE, 0, 0, 0, 0, 0)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsCLAHE(image *C.VipsImage, c CLAHE) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	err := C.vips_luminance_bridge(image, &out, C.LUMINANCE_CLAHE, C.int(c.Width), C.int(c.Height), C.int(c.MaxSlope), 0, 0)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsColourFilter(image *C.VipsImage, filter C.int, colour, highlight Color) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	first := [3]C.double{C.double(colour.R), C.double(colour.G), C.double(colour.B)}
+	second := [3]C.double{C.double(highlight.R), C.double(highlight.G), C.double(highlight.B)}
+
+	err := C.vips_colour_filter_bridge(image, &out, filter, &first[0], &second[0])
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsConvolve(image *C.VipsImage, c Convolve) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	if c.Width <= 0 || c.Height <= 0 || len(c.Kernel) != c.Width*c.Height {
+		return nil, errors.New("Convolution kernel size does not match its width and height")
+	}
+
+	kernel := make([]C.double, len(c.Kernel))
+	for i, value := range c.Kernel {
+		kernel[i] = C.double(value)
+	}
+
+	err := C.vips_convolve_bridge(image, &out, C.int(c.Width), C.int(c.Height), &kernel[0], C.double(calculateConvolveScale(c)), C.double(c.Offset))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsEdgeDetection(image *C.VipsImage, detection EdgeDetection) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	var operator C.int
+	switch detection {
+	case EdgeSobel:
+		operator = C.EDGE_SOBEL
+	case EdgeScharr:
+		operator = C.EDGE_SCHARR
+	case EdgeCanny:
+		operator = C.EDGE_CANNY
+	default:
+		return nil, errors.New("Unsupported edge detection")
+	}
+
+	err := C.vips_edge_bridge(image, &out, operator, 1.4)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsRank(image *C.VipsImage, r Rank) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	size, index := calculateRank(r)
+	err := C.vips_rank_bridge(image, &out, C.int(size), C.int(index))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsMorphology(image *C.VipsImage, m Morphology) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	width, height, element, err := calculateMorphologyMask(m)
+	if err != nil {
+		return nil, err
+	}
+
+	mask := make([]C.double, len(element))
+	for i, value := range element {
+		mask[i] = C.double(value)
+	}
+
+	operation := C.VipsOperationMorphology(C.VIPS_OPERATION_MORPHOLOGY_ERODE)
+	if m.Operation == MorphDilate {
+		operation = C.VipsOperationMorphology(C.VIPS_OPERATION_MORPHOLOGY_DILATE)
+	}
+
+	code := C.vips_morph_bridge(image, &out, C.int(width), C.int(height), &mask[0], operation)
+	if code != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsRedact(image *C.VipsImage, r Redaction) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	var mode C.int
+	switch r.Mode {
+	case RedactPixelate:
+		mode = C.REDACT_PIXELATE
+	case RedactFill:
+		mode = C.REDACT_FILL
+	default:
+		mode = C.REDACT_BLUR
+	}
+
+	values := calculateRedactionInk(r.Color, vipsInterpretation(image), int(image.Bands))
+	ink := make([]C.double, len(values))
+	for i, value := range values {
+		ink[i] = C.double(value)
+	}
+
+	err := C.vips_redact_bridge(image, &out, C.int(r.Rect.Left), C.int(r.Rect.Top), C.int(r.Rect.Width), C.int(r.Rect.Height),
+		mode, C.double(r.Strength), &ink[0])
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
 func vipsGamma(image *C.VipsImage, Gamma float64) (*C.VipsImage, error) {
 	var out *C.VipsImage
 	defer C.g_object_unref(C.gpointer(image))
@@ -40,6 +40,62 @@ enum types {
 	HEIF,
 };
 
+enum blend_modes {
+	BLEND_OVER = 0,
+	BLEND_MULTIPLY,
+	BLEND_SCREEN,
+	BLEND_OVERLAY,
+	BLEND_DARKEN,
+	BLEND_LIGHTEN,
+	BLEND_COLOUR_DODGE,
+	BLEND_COLOUR_BURN,
+	BLEND_HARD_LIGHT,
+	BLEND_SOFT_LIGHT,
+	BLEND_DIFFERENCE,
+	BLEND_EXCLUSION,
+	BLEND_ADD,
+	BLEND_SATURATE,
+};
+
+enum smartcrop_strategies {
+	SMARTCROP_ATTENTION = 0,
+	SMARTCROP_ENTROPY,
+	SMARTCROP_CENTRE,
+	SMARTCROP_LOW,
+	SMARTCROP_HIGH,
+};
+
+enum luminance_operations {
+	LUMINANCE_NORMALIZE = 0,
+	LUMINANCE_EQUALIZE,
+	LUMINANCE_CLAHE,
+};
+
+enum colour_filters {
+	FILTER_GRAYSCALE = 0,
+	FILTER_SEPIA,
+	FILTER_TINT,
+	FILTER_DUOTONE,
+};
+
+enum edge_detections {
+	EDGE_SOBEL = 0,
+	EDGE_SCHARR,
+	EDGE_CANNY,
+};
+
+enum mask_shapes {
+	MASK_CIRCLE = 1,
+	MASK_ELLIPSE,
+	MASK_ROUNDED_RECT,
+};
+
+enum redaction_modes {
+	REDACT_BLUR = 0,
+	REDACT_PIXELATE,
+	REDACT_FILL,
+};
+
 typedef struct {
 	const char *Text;
 	const char *Font;
@@ -79,6 +135,38 @@ has_alpha_channel(VipsImage *image) {
 	) ? 1 : 0;
 }
 
+/**
+ * Splits the alpha band off the image, so the colour bands can be processed
+ * on their own and the alpha band joined back untouched by vips_join_alpha.
+ * Both images belong to base, and alpha is NULL if the image has none.
+ */
+static int
+vips_split_alpha(VipsImage *base, VipsImage *in, VipsImage **image, VipsImage **alpha) {
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 2);
+
+	*image = in;
+	*alpha = NULL;
+	if (has_alpha_channel(in) == 0) {
+		return 0;
+	}
+
+	if (
+		vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+		vips_extract_band(in, &t[1], in->Bands - 1, NULL)
+	) {
+		return 1;
+	}
+
+	*image = t[0];
+	*alpha = t[1];
+	return 0;
+}
+
+static int
+vips_join_alpha(VipsImage *image, VipsImage *alpha, VipsImage **out) {
+	return alpha != NULL ? vips_bandjoin2(image, alpha, out, NULL) : vips_copy(image, out, NULL);
+}
+
 /**
  * This method is here to handle the weird initialization of the vips lib.
  * libvips use a macro VIPS_INIT() that call vips__init() in version < 7.41,
@@ -120,6 +208,171 @@ vips_webpload_buffer_shrink(void *buf, size_t len, VipsImage **out, int shrink)
 	return vips_webpload_buffer(buf, len, out, "shrink", shrink, NULL);
 }
 
+int
+vips_heifload_buffer_shrink(void *buf, size_t len, VipsImage **out, int width, int height) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 8))
+	VipsImage *thumbnail;
+
+	// Use the embedded thumbnail, as long as it is not smaller than the output
+	if (vips_heifload_buffer(buf, len, &thumbnail, "thumbnail", TRUE, NULL)) {
+		return 1;
+	}
+	if (thumbnail->Xsize >= width && thumbnail->Ysize >= height) {
+		*out = thumbnail;
+		return 0;
+	}
+	g_object_unref(thumbnail);
+
+	return vips_heifload_buffer(buf, len, out, NULL);
+#else
+	vips_error("bimg", "HEIF shrink on load requires libvips 8.8 or higher");
+	return 1;
+#endif
+}
+
+int
+vips_pdfload_buffer_shrink(void *buf, size_t len, VipsImage **out, int shrink) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 7))
+	return vips_pdfload_buffer(buf, len, out, "scale", 1.0 / shrink, NULL);
+#else
+	vips_error("bimg", "PDF shrink on load requires libvips 8.7 or higher");
+	return 1;
+#endif
+}
+
+int
+vips_svgload_buffer_shrink(void *buf, size_t len, VipsImage **out, int shrink) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 7))
+	return vips_svgload_buffer(buf, len, out, "scale", 1.0 / shrink, NULL);
+#else
+	vips_error("bimg", "SVG shrink on load requires libvips 8.7 or higher");
+	return 1;
+#endif
+}
+
+static int
+is_pyramid_level(VipsImage *level, VipsImage *in, int n) {
+	// Each pyramid level halves the size of the previous one
+	return abs(level->Xsize - (in->Xsize >> n)) <= 1 && abs(level->Ysize - (in->Ysize >> n)) <= 1;
+}
+
+int
+vips_tiffload_buffer_shrink(VipsImage *in, void *buf, size_t len, VipsImage **out, int shrink) {
+	int level;
+	int subifds = 0;
+	int pages = 1;
+
+	// Pyramid levels are stored either as subifds of the first page or as the following pages
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 10))
+	if (vips_image_get_typeof(in, "n-subifds")) {
+		vips_image_get_int(in, "n-subifds", &subifds);
+	}
+#endif
+	if (vips_image_get_typeof(in, "n-pages")) {
+		vips_image_get_int(in, "n-pages", &pages);
+	}
+
+	g_object_ref(in);
+	*out = in;
+
+	// A flat TIFF has no level to load
+	if (subifds == 0 && pages == 1) {
+		return 0;
+	}
+
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 10))
+	// Pick the smallest subifd which is not reduced more than the shrink factor
+	if (subifds > 0) {
+		for (level = 1; level <= subifds && (1 << level) <= shrink; level++) {
+			VipsImage *candidate;
+
+			if (vips_tiffload_buffer(buf, len, &candidate, "subifd", level - 1, NULL)) {
+				vips_error_clear();
+				break;
+			}
+			if (!is_pyramid_level(candidate, in, level)) {
+				g_object_unref(candidate);
+				break;
+			}
+
+			g_object_unref(*out);
+			*out = candidate;
+		}
+		return 0;
+	}
+#endif
+
+	// Pages only make a pyramid if every one of them halves the previous one,
+	// otherwise they are the pages of a document, so check all of them
+	VipsImage *best = NULL;
+	for (level = 1; level < pages; level++) {
+		VipsImage *candidate;
+
+		if (vips_tiffload_buffer(buf, len, &candidate, "page", level, NULL)) {
+			vips_error_clear();
+			break;
+		}
+		if (!is_pyramid_level(candidate, in, level)) {
+			g_object_unref(candidate);
+			break;
+		}
+
+		if ((1 << level) <= shrink) {
+			if (best != NULL) {
+				g_object_unref(best);
+			}
+			best = candidate;
+		} else {
+			g_object_unref(candidate);
+		}
+	}
+
+	if (best != NULL) {
+		if (level == pages) {
+			g_object_unref(*out);
+			*out = best;
+		} else {
+			g_object_unref(best);
+		}
+	}
+
+	return 0;
+}
+
+/**
+ * Saves the image as a pyramidal TIFF, with the levels stored either as
+ * subifds (libvips 8.10+) or as pages, or as a multi-page TIFF if page_height
+ * is set. It is used to build the shrink-on-load test fixtures.
+ */
+int
+vips_tiffsave_layout_bridge(VipsImage *in, void **buf, size_t *len, int pyramid, int subifd, int page_height) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+	int code;
+
+	if (vips_copy(in, &t[0], NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+	if (page_height > 0) {
+		vips_image_set_int(t[0], "page-height", page_height);
+	}
+
+	if (subifd) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 10))
+		code = vips_tiffsave_buffer(t[0], buf, len, "tile", INT_TO_GBOOLEAN(pyramid), "pyramid", INT_TO_GBOOLEAN(pyramid), "subifd", TRUE, NULL);
+#else
+		vips_error("bimg", "TIFF subifd pyramids require libvips 8.10+");
+		code = 1;
+#endif
+	} else {
+		code = vips_tiffsave_buffer(t[0], buf, len, "tile", INT_TO_GBOOLEAN(pyramid), "pyramid", INT_TO_GBOOLEAN(pyramid), NULL);
+	}
+
+	g_object_unref(base);
+	return code;
+}
+
 int
 vips_flip_bridge(VipsImage *in, VipsImage **out, int direction) {
 	return vips_flip(in, out, direction, NULL);
@@ -131,8 +384,12 @@ vips_shrink_bridge(VipsImage *in, VipsImage **out, double xshrink, double yshrin
 }
 
 int
-vips_reduce_bridge(VipsImage *in, VipsImage **out, double xshrink, double yshrink) {
-	return vips_reduce(in, out, xshrink, yshrink, NULL);
+vips_reduce_bridge(VipsImage *in, VipsImage **out, double xshrink, double yshrink, const char *kernel) {
+	int k = vips_enum_from_nick("bimg", VIPS_TYPE_KERNEL, kernel);
+	if (k < 0) {
+		return 1;
+	}
+	return vips_reduce(in, out, xshrink, yshrink, "kernel", k, NULL);
 }
 
 int
@@ -400,6 +657,28 @@ vips_is_16bit (VipsInterpretation interpretation) {
 	return interpretation == VIPS_INTERPRETATION_RGB16 || interpretation == VIPS_INTERPRETATION_GREY16;
 }
 
+/**
+ * Builds a background color with one value per band of the given image,
+ * scaled to the 16-bit range if needed. The alpha value, if any, is fully
+ * opaque or fully transparent. The caller must unref the returned array.
+ */
+VipsArrayDouble *
+vips_background_bridge(VipsImage *in, double r, double g, double b, int opaque) {
+	double max_alpha = vips_is_16bit(in->Type) ? 65535.0 : 255.0;
+	double alpha = opaque == 1 ? max_alpha : 0.0;
+	r = max_alpha * r / 255;
+	g = max_alpha * g / 255;
+	b = max_alpha * b / 255;
+
+	double background[4] = {r, g, b, alpha};
+	if (in->Bands < 3) {
+		background[0] = (r + g + b) / 3;
+		background[1] = alpha;
+	}
+
+	return vips_array_double_new(background, VIPS_MIN(in->Bands, 4));
+}
+
 int
 vips_flatten_background_brigde(VipsImage *in, VipsImage **out, double r, double g, double b) {
 	if (vips_is_16bit(in->Type)) {
@@ -561,6 +840,128 @@ vips_add_band(VipsImage *in, VipsImage **out, double c) {
 #endif
 }
 
+int
+vips_watermark_tile_bridge(VipsImage *in, VipsImage **out, int width, int height, int margin) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+	VipsImage *tile = in;
+
+	// Space the tiles out with transparent margins
+	if (margin > 0) {
+		if (has_alpha_channel(in) == 0) {
+			if (vips_add_band(in, &t[0], 255.0)) {
+				g_object_unref(base);
+				return 1;
+			}
+			tile = t[0];
+		}
+
+		if (vips_embed(tile, &t[1], 0, 0, tile->Xsize + margin, tile->Ysize + margin, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		tile = t[1];
+	}
+
+	if (
+		vips_replicate(tile, &t[2], 1 + width / tile->Xsize, 1 + height / tile->Ysize, NULL) ||
+		vips_crop(t[2], out, 0, 0, width, height, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_text_bridge(VipsImage **out, const char *text, const char *font, const char *fontfile, int width, int dpi, int align) {
+	if (fontfile[0] != '\0') {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 9))
+		return vips_text(out, text, "font", font, "fontfile", fontfile, "width", width, "dpi", dpi, "align", align, NULL);
+#else
+		vips_error("bimg", "font files require libvips 8.9 or higher");
+		return 1;
+#endif
+	}
+
+	return vips_text(out, text, "font", font, "width", width, "dpi", dpi, "align", align, NULL);
+}
+
+static int
+vips_colour_layer(VipsImage *mask, VipsImage **out, double *colour, double opacity) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 5);
+	double ones[3] = { 1, 1, 1 };
+
+	// Paint the colour with the mask, scaled by the colour alpha, as alpha band
+	if (
+		vips_black(&t[0], mask->Xsize, mask->Ysize, "bands", 3, NULL) ||
+		vips_linear(t[0], &t[1], ones, colour, 3, NULL) ||
+		vips_linear1(mask, &t[2], colour[3] / 255.0 * opacity, 0.0, NULL) ||
+		vips_bandjoin2(t[1], t[2], &t[3], NULL) ||
+		vips_cast(t[3], &t[4], VIPS_FORMAT_UCHAR, NULL) ||
+		vips_copy(t[4], out, "interpretation", VIPS_INTERPRETATION_sRGB, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_box_layer_bridge(VipsImage **out, int width, int height, double *colour) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 2);
+
+	if (
+		vips_black(&t[0], width, height, NULL) ||
+		vips_linear1(t[0], &t[1], 1.0, 255.0, NULL) ||
+		vips_colour_layer(t[1], out, colour, 1.0)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_text_layer_bridge(VipsImage *mask, VipsImage **out, int outline, double *fill, double *stroke, double opacity) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+	int size = 2 * outline + 1;
+
+	if (outline == 0) {
+		g_object_unref(base);
+		return vips_colour_layer(mask, out, fill, opacity);
+	}
+
+	// The outline is the text mask dilated by the outline width
+	if (
+		vips_colour_layer(mask, &t[0], fill, opacity) ||
+		vips_rank(mask, &t[1], size, size, size * size - 1, NULL) ||
+		vips_colour_layer(t[1], &t[2], stroke, opacity) ||
+		vips_composite2(t[2], t[0], &t[3], VIPS_BLEND_MODE_OVER, NULL) ||
+		vips_cast(t[3], out, VIPS_FORMAT_UCHAR, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+#else
+	vips_error("bimg", "text layers require libvips 8.6 or higher");
+	return 1;
+#endif
+}
+
 int
 vips_watermark_image(VipsImage *in, VipsImage *watermark, VipsImage **out, WatermarkImageOptions *o) {
 	// Inspired by https://gist.github.com/jcupitt/abacc012e2991f332e8b
@@ -712,15 +1113,72 @@ vips_watermark_image(VipsImage *in, VipsImage *watermark, VipsImage **out, Water
 	return 0;
 }
 
+#if (VIPS_MAJOR_VERSION >= 8 && VIPS_MINOR_VERSION >= 5)
+static VipsInteresting
+vips_interesting_bridge(int strategy) {
+	switch (strategy) {
+	case SMARTCROP_ENTROPY:
+		return VIPS_INTERESTING_ENTROPY;
+	case SMARTCROP_CENTRE:
+		return VIPS_INTERESTING_CENTRE;
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 9))
+	case SMARTCROP_LOW:
+		return VIPS_INTERESTING_LOW;
+	case SMARTCROP_HIGH:
+		return VIPS_INTERESTING_HIGH;
+#endif
+	}
+	return VIPS_INTERESTING_ATTENTION;
+}
+#endif
+
+int
+vips_smartcrop_bridge(VipsImage *in, VipsImage **out, int width, int height, int strategy) {
+#if (VIPS_MAJOR_VERSION >= 8 && VIPS_MINOR_VERSION >= 5)
+	return vips_smartcrop(in, out, width, height, "interesting", vips_interesting_bridge(strategy), NULL);
+#else
+	return 0;
+#endif
+}
+
 int
-vips_smartcrop_bridge(VipsImage *in, VipsImage **out, int width, int height) {
+vips_smartcrop_rect_bridge(VipsImage *in, int width, int height, int strategy, int *left, int *top) {
 #if (VIPS_MAJOR_VERSION >= 8 && VIPS_MINOR_VERSION >= 5)
-	return vips_smartcrop(in, out, width, height, NULL);
+	VipsImage *out;
+
+	// The crop area is chosen when the operation is built, so the
+	// extracted image is never evaluated. Its offsets point back to
+	// the origin of the area within the input image.
+	if (vips_smartcrop(in, &out, width, height, "interesting", vips_interesting_bridge(strategy), NULL)) {
+		return 1;
+	}
+
+	*left = -out->Xoffset;
+	*top = -out->Yoffset;
+
+	g_object_unref(out);
+	return 0;
 #else
 	return 0;
 #endif
 }
 
+int
+vips_thumbnail_bridge(void *buf, size_t len, VipsImage **out, int width, int height, int crop, int strategy, int force) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 7))
+	VipsSize size = force ? VIPS_SIZE_FORCE : VIPS_SIZE_BOTH;
+
+	if (crop) {
+		return vips_thumbnail_buffer(buf, len, out, width,
+			"height", height, "size", size, "crop", vips_interesting_bridge(strategy), NULL);
+	}
+	return vips_thumbnail_buffer(buf, len, out, width, "height", height, "size", size, NULL);
+#else
+	vips_error("bimg", "thumbnail requires libvips 8.7 or higher");
+	return 1;
+#endif
+}
+
 int vips_find_trim_bridge(VipsImage *in, int *top, int *left, int *width, int *height, double r, double g, double b, double threshold) {
 #if (VIPS_MAJOR_VERSION >= 8 && VIPS_MINOR_VERSION >= 6)
 	if (vips_is_16bit(in->Type)) {
@@ -741,3 +1199,936 @@ int vips_gamma_bridge(VipsImage *in, VipsImage **out, double exponent)
 {
   return vips_gamma(in, out, "exponent", 1.0 / exponent, NULL);
 }
+
+int
+vips_modulate_bridge(VipsImage *in, VipsImage **out, double brightness, double saturation, double hue) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+	VipsInterpretation interpretation = vips_image_guess_interpretation(in);
+	double multiply[3] = { brightness, saturation, 1.0 };
+	double add[3] = { 0.0, 0.0, hue };
+	VipsImage *image, *alpha;
+
+	if (vips_split_alpha(base, in, &image, &alpha)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	if (
+		vips_colourspace(image, &t[0], VIPS_INTERPRETATION_LCH, NULL) ||
+		vips_linear(t[0], &t[1], multiply, add, 3, NULL) ||
+		vips_colourspace(t[1], &t[2], interpretation, NULL) ||
+		vips_cast(t[2], &t[3], in->BandFmt, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	int code = vips_join_alpha(t[3], alpha, out);
+	g_object_unref(base);
+	return code;
+}
+
+int
+vips_linear_bridge(VipsImage *in, VipsImage **out, double contrast, double brightness) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 2);
+	double max = vips_is_16bit(in->Type) ? 65535.0 : 255.0;
+	VipsImage *image, *alpha;
+
+	if (vips_split_alpha(base, in, &image, &alpha)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	// Scale the values around the middle of the range, then shift them
+	if (
+		vips_linear1(image, &t[0], contrast, (max + 1.0) / 2.0 * (1.0 - contrast) + brightness * max, NULL) ||
+		vips_cast(t[0], &t[1], in->BandFmt, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	int code = vips_join_alpha(t[1], alpha, out);
+	g_object_unref(base);
+	return code;
+}
+
+/**
+ * Stretches the lightness of the image, with the given histogram operation,
+ * in the LAB colour space, so the colours are preserved. The lightness is
+ * processed as 8-bit values.
+ */
+int
+vips_luminance_bridge(VipsImage *in, VipsImage **out, int operation, int width, int height, int max_slope, double lower, double upper) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 12);
+	VipsInterpretation interpretation = vips_image_guess_interpretation(in);
+	VipsImage *image, *alpha;
+	int low, high;
+
+	if (vips_split_alpha(base, in, &image, &alpha)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	// Split the lightness, scaled to 8-bit values, from the colours
+	if (
+		vips_colourspace(image, &t[0], VIPS_INTERPRETATION_LAB, NULL) ||
+		vips_extract_band(t[0], &t[1], 0, NULL) ||
+		vips_extract_band(t[0], &t[2], 1, "n", 2, NULL) ||
+		vips_linear1(t[1], &t[3], 255.0 / 100.0, 0.0, NULL) ||
+		vips_cast(t[3], &t[4], VIPS_FORMAT_UCHAR, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	switch (operation) {
+	case LUMINANCE_EQUALIZE:
+		if (vips_hist_equal(t[4], &t[5], NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+	case LUMINANCE_CLAHE:
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 5))
+		if (vips_hist_local(t[4], &t[5], width, height, "max_slope", max_slope, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+#else
+		vips_error("bimg", "local contrast enhancement requires libvips 8.5+");
+		g_object_unref(base);
+		return 1;
+#endif
+	default:
+		if (
+			vips_percent(t[4], lower, &low, NULL) ||
+			vips_percent(t[4], upper, &high, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		// Flat images are left unchanged
+		if (high <= low) {
+			low = 0;
+			high = 255;
+		}
+		if (
+			vips_linear1(t[4], &t[11], 255.0 / (high - low), -low * 255.0 / (high - low), NULL) ||
+			vips_cast(t[11], &t[5], VIPS_FORMAT_UCHAR, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+	}
+
+	// Join the lightness back with the colours
+	if (
+		vips_linear1(t[5], &t[6], 100.0 / 255.0, 0.0, NULL) ||
+		vips_bandjoin2(t[6], t[2], &t[7], NULL) ||
+		vips_copy(t[7], &t[8], "interpretation", VIPS_INTERPRETATION_LAB, NULL) ||
+		vips_colourspace(t[8], &t[9], interpretation, NULL) ||
+		vips_cast(t[9], &t[10], in->BandFmt, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	int code = vips_join_alpha(t[10], alpha, out);
+	g_object_unref(base);
+	return code;
+}
+
+int
+vips_normalize_bands_bridge(VipsImage *in, VipsImage **out, double lower, double upper) {
+	VipsImage *base = vips_image_new();
+	double max = vips_is_16bit(in->Type) ? 65535.0 : 255.0;
+	VipsImage *image, *alpha;
+	int low, high;
+
+	if (vips_split_alpha(base, in, &image, &alpha)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	int bands = image->Bands;
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 2 * bands + 3);
+
+	// Stretch each band separately
+	for (int i = 0; i < bands; i++) {
+		if (
+			vips_extract_band(image, &t[2 * i], i, NULL) ||
+			vips_percent(t[2 * i], lower, &low, NULL) ||
+			vips_percent(t[2 * i], upper, &high, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		if (high <= low) {
+			low = 0;
+			high = max;
+		}
+		if (vips_linear1(t[2 * i], &t[2 * i + 1], max / (high - low), -low * max / (high - low), NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+	}
+
+	VipsImage **joined = (VipsImage **) g_new(VipsImage *, bands);
+	for (int i = 0; i < bands; i++) {
+		joined[i] = t[2 * i + 1];
+	}
+
+	int code = vips_bandjoin(joined, &t[2 * bands], bands, NULL) ||
+		vips_cast(t[2 * bands], &t[2 * bands + 1], in->BandFmt, NULL) ||
+		vips_join_alpha(t[2 * bands + 1], alpha, &t[2 * bands + 2]) ||
+		vips_copy(t[2 * bands + 2], out, "interpretation", in->Type, NULL);
+
+	g_free(joined);
+	g_object_unref(base);
+	return code;
+}
+
+/**
+ * Applies a colour filter to the image.
+ * Tint multiplies the image by the first colour in LAB. Duotone maps the
+ * lightness from the first colour to the second one.
+ */
+int
+vips_colour_filter_bridge(VipsImage *in, VipsImage **out, int filter, double *colour, double *highlight) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 14);
+	gboolean sixteen = vips_is_16bit(in->Type);
+	VipsInterpretation rgb = sixteen ? VIPS_INTERPRETATION_RGB16 : VIPS_INTERPRETATION_sRGB;
+	VipsBandFormat format = sixteen ? VIPS_FORMAT_USHORT : VIPS_FORMAT_UCHAR;
+	double scale = sixteen ? 257.0 : 1.0;
+	VipsImage *image, *alpha;
+	double *lab = NULL;
+	int n;
+
+	if (vips_split_alpha(base, in, &image, &alpha)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	switch (filter) {
+	case FILTER_GRAYSCALE:
+		if (vips_colourspace(image, &t[0], sixteen ? VIPS_INTERPRETATION_GREY16 : VIPS_INTERPRETATION_B_W, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+	case FILTER_SEPIA: {
+		double matrix[9] = {
+			0.3588, 0.7044, 0.1368,
+			0.2990, 0.5870, 0.1140,
+			0.2392, 0.4696, 0.0912,
+		};
+		t[1] = vips_image_new_matrix_from_array(3, 3, matrix, 9);
+		if (
+			t[1] == NULL ||
+			vips_colourspace(image, &t[2], rgb, NULL) ||
+			vips_recomb(t[2], &t[3], t[1], NULL) ||
+			vips_cast(t[3], &t[4], format, NULL) ||
+			vips_copy(t[4], &t[0], "interpretation", rgb, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+	}
+	case FILTER_TINT: {
+		double ones[3] = { 1.0, 1.0, 1.0 };
+		double zero[3] = { 0.0, 0.0, 0.0 };
+
+		// Convert the tint to LAB, to multiply the image by it
+		if (
+			vips_black(&t[1], 1, 1, "bands", 3, NULL) ||
+			vips_linear(t[1], &t[2], ones, colour, 3, NULL) ||
+			vips_cast(t[2], &t[3], VIPS_FORMAT_UCHAR, NULL) ||
+			vips_copy(t[3], &t[4], "interpretation", VIPS_INTERPRETATION_sRGB, NULL) ||
+			vips_colourspace(t[4], &t[5], VIPS_INTERPRETATION_LAB, NULL) ||
+			vips_getpoint(t[5], &lab, &n, 0, 0, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+
+		// Scale the lightness by the tint lightness ratio, and a and b by the
+		// tint ones normalised to their nominal range, so a neutral tint
+		// gives a neutral image
+		double multiply[3] = { lab[0] / 100.0, lab[1] / 128.0, lab[2] / 128.0 };
+		g_free(lab);
+
+		if (
+			vips_colourspace(image, &t[6], VIPS_INTERPRETATION_LAB, NULL) ||
+			vips_linear(t[6], &t[7], multiply, zero, 3, NULL) ||
+			vips_copy(t[7], &t[8], "interpretation", VIPS_INTERPRETATION_LAB, NULL) ||
+			vips_colourspace(t[8], &t[9], rgb, NULL) ||
+			vips_cast(t[9], &t[0], format, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+	}
+	case FILTER_DUOTONE: {
+		double multiply[3], add[3];
+		for (int i = 0; i < 3; i++) {
+			multiply[i] = (highlight[i] - colour[i]) * scale / 100.0;
+			add[i] = colour[i] * scale;
+		}
+
+		// Map the lightness, from 0 to 100, between both colours
+		if (
+			vips_colourspace(image, &t[1], VIPS_INTERPRETATION_LAB, NULL) ||
+			vips_extract_band(t[1], &t[2], 0, NULL) ||
+			vips_linear(t[2], &t[3], multiply, add, 3, NULL) ||
+			vips_cast(t[3], &t[4], format, NULL) ||
+			vips_copy(t[4], &t[0], "interpretation", rgb, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+	}
+	default:
+		vips_error("bimg", "unsupported colour filter");
+		g_object_unref(base);
+		return 1;
+	}
+
+	int code = vips_join_alpha(t[0], alpha, out);
+	g_object_unref(base);
+	return code;
+}
+
+int
+vips_convolve_bridge(VipsImage *in, VipsImage **out, int width, int height, double *kernel, double scale, double offset) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+	VipsImage *image, *alpha;
+
+	if (vips_split_alpha(base, in, &image, &alpha)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	t[0] = vips_image_new_matrix_from_array(width, height, kernel, width * height);
+	if (t[0] == NULL) {
+		g_object_unref(base);
+		return 1;
+	}
+	vips_image_set_double(t[0], "scale", scale);
+	vips_image_set_double(t[0], "offset", offset);
+
+	if (
+		vips_conv(image, &t[1], t[0], "precision", VIPS_PRECISION_FLOAT, NULL) ||
+		vips_cast(t[1], &t[2], in->BandFmt, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	int code = vips_join_alpha(t[2], alpha, out);
+	g_object_unref(base);
+	return code;
+}
+
+/**
+ * Detects the edges of the lightness of the image, which is converted to
+ * grayscale. Sobel and Scharr return the gradient magnitude, scaled to the
+ * range of the image, and Canny the thin edges found with the given sigma.
+ */
+int
+vips_edge_bridge(VipsImage *in, VipsImage **out, int detection, double sigma) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 11);
+	gboolean sixteen = vips_is_16bit(in->Type);
+	VipsImage *image, *alpha;
+
+	if (vips_split_alpha(base, in, &image, &alpha)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	if (vips_colourspace(image, &t[0], sixteen ? VIPS_INTERPRETATION_GREY16 : VIPS_INTERPRETATION_B_W, NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	if (detection == EDGE_CANNY) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 8))
+		if (
+			vips_canny(t[0], &t[1], "sigma", sigma, NULL) ||
+			vips_cast(t[1], &t[9], t[0]->BandFmt, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+#else
+		vips_error("bimg", "Canny edge detection requires libvips 8.8+");
+		g_object_unref(base);
+		return 1;
+#endif
+	} else {
+		double sobel[9] = { -1, 0, 1, -2, 0, 2, -1, 0, 1 };
+		double scharr[9] = { -3, 0, 3, -10, 0, 10, -3, 0, 3 };
+		double *kernel = detection == EDGE_SCHARR ? scharr : sobel;
+		double weight = detection == EDGE_SCHARR ? 16.0 : 4.0;
+
+		// The magnitude of the horizontal and vertical gradients
+		t[1] = vips_image_new_matrix_from_array(3, 3, kernel, 9);
+		if (
+			t[1] == NULL ||
+			vips_rot(t[1], &t[2], VIPS_ANGLE_D90, NULL) ||
+			vips_conv(t[0], &t[3], t[1], "precision", VIPS_PRECISION_FLOAT, NULL) ||
+			vips_conv(t[0], &t[4], t[2], "precision", VIPS_PRECISION_FLOAT, NULL) ||
+			vips_multiply(t[3], t[3], &t[5], NULL) ||
+			vips_multiply(t[4], t[4], &t[6], NULL) ||
+			vips_add(t[5], t[6], &t[7], NULL) ||
+			vips_pow_const1(t[7], &t[8], 0.5, NULL) ||
+			vips_linear1(t[8], &t[10], 1.0 / weight, 0.0, NULL) ||
+			vips_cast(t[10], &t[9], t[0]->BandFmt, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+	}
+
+	int code = vips_join_alpha(t[9], alpha, out);
+	g_object_unref(base);
+	return code;
+}
+
+int
+vips_rank_bridge(VipsImage *in, VipsImage **out, int size, int index) {
+	return vips_rank(in, out, size, size, index, NULL);
+}
+
+/**
+ * Erodes or dilates every band of the image, alpha included, which is first
+ * thresholded at the middle of its range, so the result is binary.
+ */
+int
+vips_morph_bridge(VipsImage *in, VipsImage **out, int width, int height, double *mask, VipsOperationMorphology operation) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+	double max = vips_is_16bit(in->Type) ? 65535.0 : 255.0;
+
+	t[0] = vips_image_new_matrix_from_array(width, height, mask, width * height);
+	if (
+		t[0] == NULL ||
+		vips_moreeq_const1(in, &t[1], (max + 1.0) / 2.0, NULL) ||
+		vips_morph(t[1], &t[2], t[0], operation, NULL) ||
+		vips_linear1(t[2], &t[3], max / 255.0, 0.0, NULL) ||
+		vips_cast(t[3], out, in->BandFmt, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+/**
+ * Hides the given area of the image by blurring, pixelating or filling it.
+ * Only the pixels of the area are used, so nothing leaks from around it.
+ */
+int
+vips_redact_bridge(VipsImage *in, VipsImage **out, int left, int top, int width, int height, int mode, double strength, double *ink) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 5);
+
+	switch (mode) {
+	case REDACT_PIXELATE: {
+		int block = VIPS_MAX(1, VIPS_MIN((int) strength, VIPS_MIN(width, height)));
+
+		// Average the blocks, then enlarge them back to the area size
+		if (
+			vips_extract_area(in, &t[0], left, top, width, height, NULL) ||
+			vips_shrink(t[0], &t[1], block, block, NULL) ||
+			vips_zoom(t[1], &t[2], block, block, NULL) ||
+			vips_embed(t[2], &t[3], 0, 0, width, height, "extend", VIPS_EXTEND_COPY, NULL) ||
+			vips_cast(t[3], &t[4], in->BandFmt, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+	}
+	case REDACT_FILL: {
+		// The ink holds one value per band of the image
+		double ones[in->Bands];
+		for (int i = 0; i < in->Bands; i++) {
+			ones[i] = 1.0;
+		}
+
+		if (
+			vips_black(&t[0], width, height, "bands", in->Bands, NULL) ||
+			vips_linear(t[0], &t[1], ones, ink, in->Bands, NULL) ||
+			vips_cast(t[1], &t[2], in->BandFmt, NULL) ||
+			vips_copy(t[2], &t[4], "interpretation", in->Type, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+	}
+	default:
+		if (
+			vips_extract_area(in, &t[0], left, top, width, height, NULL) ||
+			vips_gaussblur(t[0], &t[1], strength, NULL) ||
+			vips_cast(t[1], &t[4], in->BandFmt, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+	}
+
+	int code = vips_insert(in, t[4], out, left, top, NULL);
+	g_object_unref(base);
+	return code;
+}
+
+int
+vips_similarity_bridge(VipsImage *in, VipsImage **out, double angle, VipsInterpolate *interpolator, int transparent, double r, double g, double b) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+
+	// Uncovered corners can only be transparent if there is an alpha band
+	if (transparent == 1 && has_alpha_channel(in) == 0) {
+		if (vips_add_band(in, &t[0], vips_is_16bit(in->Type) ? 65535.0 : 255.0)) {
+			g_object_unref(base);
+			return 1;
+		}
+		in = t[0];
+	}
+
+	VipsArrayDouble *vipsBackground = vips_background_bridge(in, r, g, b, transparent == 1 ? 0 : 1);
+	int code = vips_similarity(in, out, "angle", angle, "interpolate", interpolator, "background", vipsBackground, NULL);
+
+	vips_area_unref(VIPS_AREA(vipsBackground));
+	g_object_unref(base);
+	return code;
+#else
+	vips_error("bimg", "arbitrary angle rotation requires libvips 8.6+");
+	return 1;
+#endif
+}
+
+int
+vips_grey_memory_bridge(VipsImage *in, void **buf, size_t *len) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+	if (
+		vips_colourspace(in, &t[0], VIPS_INTERPRETATION_B_W, NULL) ||
+		vips_extract_band(t[0], &t[1], 0, NULL) ||
+		vips_cast(t[1], &t[2], VIPS_FORMAT_UCHAR, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	*buf = vips_image_write_to_memory(t[2], len);
+
+	g_object_unref(base);
+	return *buf == NULL ? 1 : 0;
+}
+
+int
+vips_border_bridge(VipsImage *in, VipsImage **out, int width, double r, double g, double b) {
+	// Unlike embed backgrounds, borders are always opaque
+	VipsArrayDouble *vipsBackground = vips_background_bridge(in, r, g, b, 1);
+	int code = vips_embed(in, out, width, width, in->Xsize + 2 * width, in->Ysize + 2 * width,
+		"extend", VIPS_EXTEND_BACKGROUND,
+		"background", vipsBackground,
+		NULL
+	);
+
+	vips_area_unref(VIPS_AREA(vipsBackground));
+	return code;
+}
+
+int
+vips_mask_shape_bridge(VipsImage **out, int width, int height, int shape, double radius) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 24);
+	double half_width = width / 2.0, half_height = height / 2.0;
+	double half = VIPS_MIN(half_width, half_height);
+	double r = VIPS_MIN(radius, half);
+	double sum[2] = { 1.0, 1.0 };
+	double mean[2] = { 0.5, 0.5 };
+	double spread[2] = { 0.5, -0.5 };
+
+	// Offset of every pixel centre from the image centre, as [dx, dy]
+	double ones[2] = { 1.0, 1.0 };
+	double centre[2] = { 0.5 - half_width, 0.5 - half_height };
+
+	t[20] = vips_image_new_matrix_from_array(2, 1, sum, 2);
+	t[21] = vips_image_new_matrix_from_array(2, 1, mean, 2);
+	t[22] = vips_image_new_matrix_from_array(2, 1, spread, 2);
+	if (
+		t[20] == NULL || t[21] == NULL || t[22] == NULL ||
+		vips_xyz(&t[0], width, height, NULL) ||
+		vips_linear(t[0], &t[1], ones, centre, 2, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	// Signed distance from the pixel centre to the shape edge, negative inside,
+	// computed lazily by libvips so no full size buffer is ever allocated
+	switch (shape) {
+	case MASK_CIRCLE:
+		if (
+			vips_multiply(t[1], t[1], &t[2], NULL) ||
+			vips_recomb(t[2], &t[3], t[20], NULL) ||
+			vips_pow_const1(t[3], &t[4], 0.5, NULL) ||
+			vips_linear1(t[4], &t[19], 1.0, -half, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+	case MASK_ELLIPSE: {
+		// First order distance to the ellipse, f / |grad f|, with
+		// f = dx²/a² + dy²/b² - 1
+		double scale[2] = { 1.0 / (half_width * half_width), 1.0 / (half_height * half_height) };
+		double zero[2] = { 0.0, 0.0 };
+		if (
+			vips_linear(t[1], &t[2], scale, zero, 2, NULL) ||
+			vips_multiply(t[1], t[2], &t[3], NULL) ||
+			vips_recomb(t[3], &t[4], t[20], NULL) ||
+			vips_linear1(t[4], &t[5], 1.0, -1.0, NULL) ||
+			vips_multiply(t[2], t[2], &t[6], NULL) ||
+			vips_recomb(t[6], &t[7], t[20], NULL) ||
+			vips_pow_const1(t[7], &t[8], 0.5, NULL) ||
+			// Keep the gradient away from zero at the exact centre
+			vips_linear1(t[8], &t[9], 2.0, 1e-10, NULL) ||
+			vips_divide(t[5], t[9], &t[19], NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+	}
+	default: {
+		// Rounded box distance, with q = |d| - (half size - r):
+		// |max(q, 0)| + min(max(qx, qy), 0) - r
+		double inset[2] = { r - half_width, r - half_height };
+		if (
+			vips_abs(t[1], &t[2], NULL) ||
+			vips_linear(t[2], &t[3], ones, inset, 2, NULL) ||
+			// max(q, 0) = (q + |q|) / 2
+			vips_abs(t[3], &t[4], NULL) ||
+			vips_add(t[3], t[4], &t[5], NULL) ||
+			vips_multiply(t[5], t[5], &t[6], NULL) ||
+			vips_recomb(t[6], &t[7], t[20], NULL) ||
+			vips_pow_const1(t[7], &t[8], 0.5, NULL) ||
+			// max(qx, qy) = (qx + qy) / 2 + |qx - qy| / 2
+			vips_recomb(t[3], &t[9], t[21], NULL) ||
+			vips_recomb(t[3], &t[10], t[22], NULL) ||
+			vips_abs(t[10], &t[11], NULL) ||
+			vips_add(t[9], t[11], &t[12], NULL) ||
+			// min(m, 0) = (m - |m|) / 2
+			vips_abs(t[12], &t[13], NULL) ||
+			vips_subtract(t[12], t[13], &t[14], NULL) ||
+			vips_add(t[8], t[14], &t[15], NULL) ||
+			vips_linear1(t[15], &t[19], 0.5, -r, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		break;
+	}
+	}
+
+	// Anti-aliased coverage, clamp(0.5 - distance, 0, 1), rounded to 8-bit
+	if (
+		vips_linear1(t[19], &t[23], -255.0, 128.0, NULL) ||
+		vips_floor(t[23], &t[18], NULL) ||
+		vips_cast(t[18], out, VIPS_FORMAT_UCHAR, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_mask_image_bridge(VipsImage *mask, VipsImage **out, int width, int height) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+
+	// Use the alpha band of the mask, or its luminance if it has none
+	if (has_alpha_channel(mask) == 1) {
+		if (vips_extract_band(mask, &t[0], mask->Bands - 1, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+	} else if (
+		vips_colourspace(mask, &t[1], VIPS_INTERPRETATION_B_W, NULL) ||
+		vips_extract_band(t[1], &t[0], 0, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	if (
+		vips_resize(t[0], &t[2], (double) width / mask->Xsize, "vscale", (double) height / mask->Ysize, NULL) ||
+		vips_embed(t[2], &t[3], 0, 0, width, height, "extend", VIPS_EXTEND_COPY, NULL) ||
+		vips_cast(t[3], out, VIPS_FORMAT_UCHAR, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_apply_mask_bridge(VipsImage *in, VipsImage *mask, VipsImage **out) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 6);
+	double max_alpha = vips_is_16bit(in->Type) ? 65535.0 : 255.0;
+
+	// Scale the 8-bit mask to the alpha range of the image
+	if (vips_linear1(mask, &t[0], max_alpha / 255.0, 0.0, NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	if (has_alpha_channel(in) == 0) {
+		if (
+			vips_cast(t[0], &t[1], in->BandFmt, NULL) ||
+			vips_bandjoin2(in, t[1], out, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		g_object_unref(base);
+		return 0;
+	}
+
+	// Multiply the existing alpha band by the mask
+	if (
+		vips_extract_band(in, &t[1], 0, "n", in->Bands - 1, NULL) ||
+		vips_extract_band(in, &t[2], in->Bands - 1, NULL) ||
+		vips_multiply(t[2], t[0], &t[3], NULL) ||
+		vips_linear1(t[3], &t[4], 1.0 / max_alpha, 0.0, NULL) ||
+		vips_cast(t[4], &t[5], in->BandFmt, NULL) ||
+		vips_bandjoin2(t[1], t[5], out, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+static VipsBlendMode
+vips_blend_mode_bridge(int mode) {
+	switch (mode) {
+	case BLEND_MULTIPLY:
+		return VIPS_BLEND_MODE_MULTIPLY;
+	case BLEND_SCREEN:
+		return VIPS_BLEND_MODE_SCREEN;
+	case BLEND_OVERLAY:
+		return VIPS_BLEND_MODE_OVERLAY;
+	case BLEND_DARKEN:
+		return VIPS_BLEND_MODE_DARKEN;
+	case BLEND_LIGHTEN:
+		return VIPS_BLEND_MODE_LIGHTEN;
+	case BLEND_COLOUR_DODGE:
+		return VIPS_BLEND_MODE_COLOUR_DODGE;
+	case BLEND_COLOUR_BURN:
+		return VIPS_BLEND_MODE_COLOUR_BURN;
+	case BLEND_HARD_LIGHT:
+		return VIPS_BLEND_MODE_HARD_LIGHT;
+	case BLEND_SOFT_LIGHT:
+		return VIPS_BLEND_MODE_SOFT_LIGHT;
+	case BLEND_DIFFERENCE:
+		return VIPS_BLEND_MODE_DIFFERENCE;
+	case BLEND_EXCLUSION:
+		return VIPS_BLEND_MODE_EXCLUSION;
+	case BLEND_ADD:
+		return VIPS_BLEND_MODE_ADD;
+	case BLEND_SATURATE:
+		return VIPS_BLEND_MODE_SATURATE;
+	}
+	return VIPS_BLEND_MODE_OVER;
+}
+#endif
+
+int
+vips_composite_bridge(VipsImage *in, VipsImage **layers, int n, int *modes, int *left, int *top, double *opacity, VipsImage **out) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4 * n + 2);
+	VipsImage **images = VIPS_ARRAY(VIPS_OBJECT(base), n + 1, VipsImage *);
+	int *blend = VIPS_ARRAY(VIPS_OBJECT(base), n, int);
+	int i, b;
+
+	images[0] = in;
+
+	for (i = 0; i < n; i++) {
+		VipsImage *layer = layers[i];
+
+		if (has_alpha_channel(layer) == 0) {
+			if (vips_addalpha(layer, &t[4 * i], NULL)) {
+				g_object_unref(base);
+				return 1;
+			}
+			layer = t[4 * i];
+		}
+
+		// Scale the alpha band by the layer opacity
+		if (opacity[i] < 1.0) {
+			double *a = VIPS_ARRAY(VIPS_OBJECT(base), layer->Bands, double);
+			double *c = VIPS_ARRAY(VIPS_OBJECT(base), layer->Bands, double);
+
+			for (b = 0; b < layer->Bands; b++) {
+				a[b] = 1.0;
+				c[b] = 0.0;
+			}
+			a[layer->Bands - 1] = opacity[i];
+
+			if (
+				vips_linear(layer, &t[4 * i + 1], a, c, layer->Bands, NULL) ||
+				vips_cast(t[4 * i + 1], &t[4 * i + 2], layer->BandFmt, NULL)
+			) {
+				g_object_unref(base);
+				return 1;
+			}
+			layer = t[4 * i + 2];
+		}
+
+		// Place the layer on a transparent canvas with the size of the image
+		if (vips_embed(layer, &t[4 * i + 3], left[i], top[i], in->Xsize, in->Ysize, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+
+		images[i + 1] = t[4 * i + 3];
+		blend[i] = vips_blend_mode_bridge(modes[i]);
+	}
+
+	if (vips_composite(images, &t[4 * n], n + 1, blend, NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	// Opaque images remain opaque with every supported blend mode
+	if (has_alpha_channel(in) == 1) {
+		if (vips_cast(t[4 * n], out, in->BandFmt, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+	} else if (
+		vips_cast(t[4 * n], &t[4 * n + 1], in->BandFmt, NULL) ||
+		vips_extract_band(t[4 * n + 1], out, 0, "n", t[4 * n + 1]->Bands - 1, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+#else
+	vips_error("bimg", "composite requires libvips 8.6 or higher");
+	return 1;
+#endif
+}
+
+int
+vips_affine_matrix_bridge(VipsImage *in, VipsImage **out, double *matrix, VipsInterpolate *interpolator, int extend, double r, double g, double b) {
+	VipsArrayDouble *vipsBackground = vips_background_bridge(in, r, g, b, 1);
+	int code = vips_affine(in, out, matrix[0], matrix[1], matrix[3], matrix[4],
+		"interpolate", interpolator,
+		"extend", extend,
+		"background", vipsBackground,
+		NULL
+	);
+
+	vips_area_unref(VIPS_AREA(vipsBackground));
+	return code;
+}
+
+int
+vips_perspective_bridge(VipsImage *in, VipsImage **out, double *matrix, int width, int height, VipsInterpolate *interpolator, int extend, double r, double g, double b) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 7))
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 7);
+
+	// Build the index image holding the source coordinates of every output
+	// pixel, mapping [x, y, 1] through the homography matrix
+	t[0] = vips_image_new_matrix_from_array(3, 3, matrix, 9);
+	if (
+		t[0] == NULL ||
+		vips_xyz(&t[1], width, height, NULL) ||
+		vips_bandjoin_const1(t[1], &t[2], 1.0, NULL) ||
+		vips_recomb(t[2], &t[3], t[0], NULL) ||
+		vips_extract_band(t[3], &t[4], 0, "n", 2, NULL) ||
+		vips_extract_band(t[3], &t[5], 2, NULL) ||
+		vips_divide(t[4], t[5], &t[6], NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 13))
+	VipsArrayDouble *vipsBackground = vips_background_bridge(in, r, g, b, 1);
+	int code = vips_mapim(in, out, t[6],
+		"interpolate", interpolator,
+		"extend", extend,
+		"background", vipsBackground,
+		NULL
+	);
+	vips_area_unref(VIPS_AREA(vipsBackground));
+#else
+	int code = vips_mapim(in, out, t[6], "interpolate", interpolator, NULL);
+#endif
+
+	g_object_unref(base);
+	return code;
+#else
+	vips_error("bimg", "perspective transform requires libvips 8.7+");
+	return 1;
+#endif
+}
+
+int
+vips_premultiply_bridge(VipsImage *in, VipsImage **out) {
+	return vips_premultiply(in, out, "max_alpha", vips_is_16bit(in->Type) ? 65535.0 : 255.0, NULL);
+}
+
+int
+vips_unpremultiply_bridge(VipsImage *in, VipsImage **out, VipsBandFormat format) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+
+	// Premultiplied images are float, so restore the original band format
+	if (
+		vips_unpremultiply(in, &t[0], "max_alpha", vips_is_16bit(in->Type) ? 65535.0 : 255.0, NULL) ||
+		vips_cast(t[0], out, format, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}